@@ -5,6 +5,8 @@ go 1.19
 require (
 	github.com/gorilla/mux v1.8.1
 	github.com/gorilla/websocket v1.5.3
+	go.etcd.io/bbolt v1.3.8
+	golang.org/x/crypto v0.31.0
 )
 
-require golang.org/x/crypto v0.31.0 // indirect
+require golang.org/x/sys v0.28.0 // indirect
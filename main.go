@@ -4,6 +4,9 @@ import (
 	service "chess_backend/service"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 
 	"github.com/gorilla/mux"
 )
@@ -11,15 +14,57 @@ import (
 func main() {
 	router := mux.NewRouter()
 	userStore := service.SetupUserStore()
-	onlineUsersManager := service.NewOnlineUsersManager(userStore)
+	gameRepository := service.SetupGameRepository()
+	tokenAuthority := service.SetupTokenAuthority()
+	onlineUsersManager := service.NewOnlineUsersManager(userStore, gameRepository, tokenAuthority)
 
-	router.HandleFunc("/users/create", service.CreateUserHandler(userStore)).Methods("POST")
-	router.HandleFunc("/users/get", service.GetUserHandler(userStore)).Methods("GET")
-	router.HandleFunc("/users/disconnect", service.DisconnectUserHandler(userStore, onlineUsersManager)).Methods("DELETE")
+	// Reprendre les parties en cours lors de l'arrêt précédent, puis
+	// enregistrer régulièrement leur état
+	snapshotStore := service.SetupSnapshotStore()
+	if _, err := onlineUsersManager.RestoreSnapshot(snapshotStore); err != nil {
+		log.Printf("Warning: Error restoring games: %v", err)
+	}
+	go onlineUsersManager.RunSnapshots(snapshotStore)
+
+	// Toutes les routes sauf l'ouverture de session exigent un jeton
+	auth := func(handler http.HandlerFunc) http.HandlerFunc {
+		return service.RequireAuth(tokenAuthority, userStore, handler)
+	}
+
+	// Comptes : invité pour la partie rapide, ou enregistré avec mot de passe
+	loginThrottler := service.NewLoginThrottler()
+	router.HandleFunc("/users/create", service.CreateUserHandler(userStore, tokenAuthority)).Methods("POST")
+	router.HandleFunc("/auth/register", service.RegisterHandler(userStore, tokenAuthority)).Methods("POST")
+	router.HandleFunc("/auth/login", service.LoginHandler(userStore, tokenAuthority, loginThrottler, onlineUsersManager.Presence())).Methods("POST")
+	router.HandleFunc("/auth/password", auth(service.ChangePasswordHandler(userStore, tokenAuthority, loginThrottler, onlineUsersManager.Presence()))).Methods("POST")
+	router.HandleFunc("/users/get", auth(service.GetUserHandler(userStore, onlineUsersManager.Presence()))).Methods("GET")
+	router.HandleFunc("/users/disconnect", auth(service.DisconnectUserHandler(userStore, onlineUsersManager))).Methods("DELETE")
+
+	router.HandleFunc("/users/{name}/ratings", auth(service.UserRatingsHandler(userStore))).Methods("GET")
+	router.HandleFunc("/users/{name}/games", auth(service.ListUserGamesHandler(onlineUsersManager))).Methods("GET")
+
+	// /games/live doit être déclarée avant /games/{id}
+	router.HandleFunc("/games/live", auth(service.LiveGamesHandler(onlineUsersManager))).Methods("GET")
+	router.HandleFunc("/games/{id}", auth(service.GetGameHandler(onlineUsersManager))).Methods("GET")
+	router.HandleFunc("/games/{id}/pgn", auth(service.GamePGNHandler(onlineUsersManager))).Methods("GET")
 
 	// Routes WebSocket
 	router.HandleFunc("/ws", onlineUsersManager.HandleConnection)
 
+	// Écrire l'état des parties et les profils en attente avant de quitter
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+		<-signals
+		if err := onlineUsersManager.WriteSnapshot(snapshotStore); err != nil {
+			log.Printf("Error writing game snapshot: %v", err)
+		}
+		if err := userStore.Close(); err != nil {
+			log.Printf("Error closing user store: %v", err)
+		}
+		os.Exit(0)
+	}()
+
 	port := service.Getenv("PORT", "8081")
 	log.Printf("Running user management server on port :%s...", port)
 
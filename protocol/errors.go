@@ -0,0 +1,57 @@
+package protocol
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Codes d'erreur renvoyés au client dans les messages de type error
+const (
+	CodeInvalidMessage     = "invalid_message"
+	CodeUnknownType        = "unknown_type"
+	CodeUnsupportedVersion = "unsupported_version"
+	CodeInvalidPayload     = "invalid_payload"
+	CodeForbidden          = "forbidden"
+	CodeRoomNotFound       = "room_not_found"
+	CodeRoomExists         = "room_exists"
+	CodeNotInRoom          = "not_in_room"
+	CodeNotYourTurn        = "not_your_turn"
+	CodeWrongColor         = "wrong_color"
+	CodeNotInvited         = "not_invited"
+	CodeIllegalMove        = "illegal_move"
+	CodeGameOver           = "game_over"
+	CodeInvalidClaim       = "invalid_claim"
+	CodeUserOffline        = "user_offline"
+	CodeAlreadyInGame      = "already_in_game"
+	CodeActionRejected     = "action_rejected"
+	CodeRateLimited        = "rate_limited"
+	CodeDeliveryFailed     = "delivery_failed"
+	CodeBadRequest         = "bad_request"
+)
+
+// Erreur de protocole : un code stable pour le client et un message lisible
+type Error struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func NewError(code, message string) *Error {
+	return &Error{Code: code, Message: message}
+}
+
+func Errorf(code, format string, args ...interface{}) *Error {
+	return &Error{Code: code, Message: fmt.Sprintf(format, args...)}
+}
+
+// Code d'une erreur quelconque, bad_request si elle n'est pas typée
+func ErrorCode(err error) string {
+	var protocolErr *Error
+	if errors.As(err, &protocolErr) {
+		return protocolErr.Code
+	}
+	return CodeBadRequest
+}
@@ -0,0 +1,145 @@
+package protocol
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+// Charge utile d'un message reçu, validée avant d'être transmise au handler
+type Payload interface {
+	Validate() error
+}
+
+func required(value, field string) error {
+	if strings.TrimSpace(value) == "" {
+		return Errorf(CodeInvalidPayload, "%s is required", field)
+	}
+	return nil
+}
+
+type Hello struct {
+	Version    int `json:"version"`
+	MinVersion int `json:"minVersion,omitempty"`
+}
+
+func (p *Hello) Validate() error {
+	if p.Version < 1 {
+		return NewError(CodeInvalidPayload, "version is required")
+	}
+	if p.MinVersion < 0 || (p.MinVersion > 0 && p.MinVersion > p.Version) {
+		return NewError(CodeInvalidPayload, "minVersion must not exceed version")
+	}
+	return nil
+}
+
+// Message sans charge utile
+type Empty struct{}
+
+func (p *Empty) Validate() error { return nil }
+
+type TimeControl struct {
+	BaseMinutes      int    `json:"base_minutes"`
+	IncrementSeconds int    `json:"increment_seconds"`
+	DelaySeconds     int    `json:"delay_seconds"`
+	DelayType        string `json:"delay_type,omitempty"`
+}
+
+// Champs communs aux messages d'invitation. Les champs d'identité sont
+// acceptés pour compatibilité mais vérifiés contre la connexion.
+type Invitation struct {
+	Type         string       `json:"type,omitempty"`
+	FromUserID   string       `json:"from_user_id,omitempty"`
+	FromUsername string       `json:"from_username,omitempty"`
+	ToUserID     string       `json:"to_user_id,omitempty"`
+	ToUsername   string       `json:"to_username,omitempty"`
+	RoomID       string       `json:"room_id,omitempty"`
+	TimeControl  *TimeControl `json:"time_control,omitempty"`
+}
+
+type InvitationSend struct {
+	Invitation
+}
+
+func (p *InvitationSend) Validate() error {
+	return required(p.ToUsername, "to_username")
+}
+
+// Acceptation, refus ou annulation d'une invitation existante
+type InvitationReply struct {
+	Invitation
+}
+
+func (p *InvitationReply) Validate() error {
+	return required(p.RoomID, "room_id")
+}
+
+// Départ d'une room. Sans room_id, la room courante du joueur est quittée
+// (ancien message leave_room).
+type RoomLeave struct {
+	Invitation
+	GameID   string `json:"gameId,omitempty"`
+	Username string `json:"username,omitempty"`
+}
+
+func (p *RoomLeave) Validate() error { return nil }
+
+// Identifiant de la room visée
+func (p *RoomLeave) Room() string {
+	if p.RoomID != "" {
+		return p.RoomID
+	}
+	return p.GameID
+}
+
+type GameMove struct {
+	GameID     string          `json:"gameId"`
+	Move       json.RawMessage `json:"move"`
+	FromUserID string          `json:"fromUserId,omitempty"`
+	ToUserID   string          `json:"toUserId,omitempty"`
+	ToUsername string          `json:"toUsername,omitempty"`
+}
+
+func (p *GameMove) Validate() error {
+	if err := required(p.GameID, "gameId"); err != nil {
+		return err
+	}
+	if len(p.Move) == 0 || string(p.Move) == "null" {
+		return NewError(CodeInvalidPayload, "move is required")
+	}
+	return nil
+}
+
+// Message visant une partie : abandon, nulle, annulation de coup...
+type GameAction struct {
+	GameID string `json:"gameId"`
+}
+
+func (p *GameAction) Validate() error {
+	return required(p.GameID, "gameId")
+}
+
+// Réclamation de fin de partie ; le résultat est toujours recalculé par le serveur
+type GameOverClaim struct {
+	GameAction
+	Winner   string `json:"winner,omitempty"`
+	Reason   string `json:"reason,omitempty"`
+	WinnerID string `json:"winnerId,omitempty"`
+}
+
+type PublicGameRequest struct {
+	TimeControl *TimeControl `json:"time_control,omitempty"`
+}
+
+func (p *PublicGameRequest) Validate() error { return nil }
+
+type ChatMessage struct {
+	GameID string `json:"gameId"`
+	Text   string `json:"text"`
+}
+
+func (p *ChatMessage) Validate() error {
+	if err := required(p.GameID, "gameId"); err != nil {
+		return err
+	}
+	return required(p.Text, "text")
+}
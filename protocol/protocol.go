@@ -0,0 +1,233 @@
+// Package protocol décrit les messages échangés sur la WebSocket : enveloppe,
+// types de messages, charges utiles typées, erreurs et négociation de version.
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+)
+
+const (
+	// Version 1 : protocole historique, sans handshake. Le contenu est une
+	// chaîne JSON et les champs inconnus sont ignorés.
+	Version1 = 1
+	// Version 2 : handshake hello, contenu en objet JSON, validation stricte
+	// et noms de champs corrigés dans les messages sortants.
+	Version2 = 2
+
+	MinVersion     = Version1
+	CurrentVersion = Version2
+
+	// Longueur maximale de l'identifiant d'un message
+	MaxIDLength = 64
+)
+
+// Types des messages reçus du client
+const (
+	TypeHello              = "hello"
+	TypeRequestOnlineUsers = "request_online_users"
+	TypeInvitationSend     = "invitation_send"
+	TypeInvitationAccept   = "invitation_accept"
+	TypeInvitationReject   = "invitation_reject"
+	TypeInvitationCancel   = "invitation_cancel"
+	TypeRoomLeave          = "room_leave"
+	TypeGameMove           = "game_move"
+	TypeGameOverClaim      = "game_over_claim"
+	TypeGameResign         = "game_resign"
+	TypeDrawOffer          = "draw_offer"
+	TypeDrawAccept         = "draw_accept"
+	TypeDrawDecline        = "draw_decline"
+	TypeGameAbort          = "game_abort"
+	TypeTakebackRequest    = "takeback_request"
+	TypeTakebackAccept     = "takeback_accept"
+	TypeTakebackDecline    = "takeback_decline"
+	TypePublicGameRequest  = "public_game_request"
+	TypePublicQueueLeave   = "public_queue_leave"
+	TypeSpectateJoin       = "spectate_join"
+	TypeSpectateLeave      = "spectate_leave"
+	TypeChatMessage        = "chat_message"
+)
+
+// Réponses du serveur à un message identifié
+const (
+	TypeAck   = "ack"
+	TypeError = "error"
+)
+
+// Anciens noms de messages encore envoyés par les clients existants
+var legacyTypes = map[string]string{
+	"leave_room":          TypeRoomLeave,
+	"game_over_checkmate": TypeGameOverClaim,
+}
+
+// Champs mal nommés du protocole historique, renommés pour les clients v2
+var legacyFields = map[string]string{
+	"positonFen": "positionFen",
+	"isnOline":   "isOnline",
+}
+
+// Nom canonique d'un type de message
+func CanonicalType(msgType string) string {
+	if canonical, exists := legacyTypes[msgType]; exists {
+		return canonical
+	}
+	return msgType
+}
+
+// Message reçu : son type, l'identifiant optionnel choisi par le client et
+// sa charge utile JSON
+type Envelope struct {
+	Type    string
+	ID      string
+	Payload json.RawMessage
+}
+
+// Décode un message reçu. Le contenu peut être une chaîne contenant du JSON
+// (protocole historique) ou directement un objet JSON. En cas d'erreur,
+// l'enveloppe garde l'identifiant déjà lu pour que la réponse puisse le porter.
+func DecodeEnvelope(data []byte) (Envelope, error) {
+	var raw struct {
+		Type    string          `json:"type"`
+		ID      json.RawMessage `json:"id"`
+		Content json.RawMessage `json:"content"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return Envelope{}, NewError(CodeInvalidMessage, "message is not valid JSON")
+	}
+
+	envelope := Envelope{Type: raw.Type, Payload: json.RawMessage("{}")}
+	id, err := decodeID(raw.ID)
+	if err != nil {
+		return envelope, err
+	}
+	envelope.ID = id
+	if raw.Type == "" {
+		return envelope, NewError(CodeInvalidMessage, "message type is required")
+	}
+
+	content := bytes.TrimSpace(raw.Content)
+	switch {
+	case len(content) == 0 || bytes.Equal(content, []byte("null")):
+	case content[0] == '"':
+		var inner string
+		if err := json.Unmarshal(content, &inner); err != nil {
+			return envelope, NewError(CodeInvalidMessage, "invalid content")
+		}
+		if inner = string(bytes.TrimSpace([]byte(inner))); inner != "" {
+			envelope.Payload = json.RawMessage(inner)
+		}
+	case content[0] == '{':
+		envelope.Payload = content
+	default:
+		return envelope, NewError(CodeInvalidMessage, "content must be a JSON object")
+	}
+	return envelope, nil
+}
+
+// L'identifiant d'un message peut être une chaîne ou un nombre ; il est
+// renvoyé au client sous forme de chaîne
+func decodeID(raw json.RawMessage) (string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || bytes.Equal(raw, []byte("null")) {
+		return "", nil
+	}
+
+	var id string
+	if raw[0] == '"' {
+		if err := json.Unmarshal(raw, &id); err != nil {
+			return "", NewError(CodeInvalidMessage, "invalid message id")
+		}
+	} else {
+		var number json.Number
+		if err := json.Unmarshal(raw, &number); err != nil {
+			return "", NewError(CodeInvalidMessage, "message id must be a string or a number")
+		}
+		id = number.String()
+	}
+	if len(id) > MaxIDLength {
+		return "", NewError(CodeInvalidMessage, fmt.Sprintf("message id is too long (max %d characters)", MaxIDLength))
+	}
+	return id, nil
+}
+
+// Message sortant au format v2 : contenu en objet JSON
+type outgoing struct {
+	Type    string          `json:"type"`
+	ID      string          `json:"id,omitempty"`
+	Content json.RawMessage `json:"content,omitempty"`
+}
+
+// Prépare un message sortant pour la version négociée. En v1 le contenu reste
+// une chaîne ; en v2 il devient un objet et les champs historiques sont renommés.
+// id est l'identifiant du message client auquel il répond, s'il y en a un.
+func Encode(version int, msgType string, id string, content string) interface{} {
+	if version < Version2 {
+		return struct {
+			Type    string `json:"type"`
+			ID      string `json:"id,omitempty"`
+			Content string `json:"content"`
+		}{msgType, id, content}
+	}
+
+	var value interface{}
+	if err := json.Unmarshal([]byte(content), &value); err != nil {
+		// Contenu non JSON : conservé tel quel
+		data, _ := json.Marshal(content)
+		return outgoing{Type: msgType, ID: id, Content: data}
+	}
+	data, err := json.Marshal(renameLegacyFields(value))
+	if err != nil {
+		return outgoing{Type: msgType, ID: id}
+	}
+	return outgoing{Type: msgType, ID: id, Content: data}
+}
+
+// Contenu d'un accusé de réception : le type du message accepté
+type Ack struct {
+	Type string `json:"type"`
+}
+
+func renameLegacyFields(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		renamed := make(map[string]interface{}, len(v))
+		for key, field := range v {
+			if name, exists := legacyFields[key]; exists {
+				key = name
+			}
+			renamed[key] = renameLegacyFields(field)
+		}
+		return renamed
+	case []interface{}:
+		for i, item := range v {
+			v[i] = renameLegacyFields(item)
+		}
+		return v
+	}
+	return value
+}
+
+// Version retenue pour une session : la plus haute commune au client et au serveur
+func Negotiate(hello *Hello) (int, error) {
+	clientMin := hello.MinVersion
+	if clientMin == 0 {
+		clientMin = MinVersion
+	}
+	version := hello.Version
+	if version > CurrentVersion {
+		version = CurrentVersion
+	}
+	if version < MinVersion || version < clientMin {
+		return 0, NewError(CodeUnsupportedVersion, fmt.Sprintf("no common protocol version (server supports %d to %d)", MinVersion, CurrentVersion))
+	}
+	return version, nil
+}
+
+// Réponse au handshake
+type Welcome struct {
+	Version       int      `json:"version"`
+	ServerVersion int      `json:"serverVersion"`
+	MinVersion    int      `json:"minVersion"`
+	MessageTypes  []string `json:"messageTypes"`
+}
@@ -0,0 +1,87 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+)
+
+type route[C any] struct {
+	decode func(data json.RawMessage, strict bool) (Payload, error)
+	handle func(client C, payload Payload) error
+}
+
+// Vérification appliquée à chaque message avant son handler (autorisation...)
+type Guard[C any] func(client C, msgType string, payload Payload) error
+
+// Handlers des messages reçus, indexés par type. C est la session du client.
+type Registry[C any] struct {
+	routes map[string]route[C]
+	guards []Guard[C]
+}
+
+func NewRegistry[C any]() *Registry[C] {
+	return &Registry[C]{routes: make(map[string]route[C])}
+}
+
+// Enregistre le handler d'un type de message. La charge utile est décodée
+// dans P puis validée avant l'appel.
+func Handle[C any, P any, PP interface {
+	*P
+	Payload
+}](r *Registry[C], msgType string, handler func(client C, payload PP) error) {
+	r.routes[msgType] = route[C]{
+		decode: func(data json.RawMessage, strict bool) (Payload, error) {
+			payload := PP(new(P))
+			decoder := json.NewDecoder(bytes.NewReader(data))
+			if strict {
+				decoder.DisallowUnknownFields()
+			}
+			if err := decoder.Decode(payload); err != nil {
+				return nil, Errorf(CodeInvalidPayload, "invalid %s payload: %v", msgType, err)
+			}
+			return payload, nil
+		},
+		handle: func(client C, payload Payload) error {
+			return handler(client, payload.(PP))
+		},
+	}
+}
+
+func (r *Registry[C]) Use(guard Guard[C]) {
+	r.guards = append(r.guards, guard)
+}
+
+// Types de messages acceptés, triés
+func (r *Registry[C]) Types() []string {
+	types := make([]string, 0, len(r.routes))
+	for msgType := range r.routes {
+		types = append(types, msgType)
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Décode, valide et transmet un message à son handler. La validation est
+// stricte (champs inconnus refusés) à partir de la version 2.
+func (r *Registry[C]) Dispatch(client C, version int, envelope Envelope) error {
+	msgType := CanonicalType(envelope.Type)
+	route, exists := r.routes[msgType]
+	if !exists {
+		return Errorf(CodeUnknownType, "unknown message type %q", envelope.Type)
+	}
+
+	payload, err := route.decode(envelope.Payload, version >= Version2)
+	if err != nil {
+		return err
+	}
+	if err := payload.Validate(); err != nil {
+		return err
+	}
+	for _, guard := range r.guards {
+		if err := guard(client, msgType, payload); err != nil {
+			return err
+		}
+	}
+	return route.handle(client, payload)
+}
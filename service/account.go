@@ -0,0 +1,365 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+type AccountType string
+
+const (
+	AccountGuest      AccountType = "guest"
+	AccountRegistered AccountType = "registered"
+)
+
+const (
+	minUsernameLength = 3
+	maxUsernameLength = 20
+	minPasswordLength = 8
+	// bcrypt ignore tout au-delà de 72 octets
+	maxPasswordLength = 72
+
+	// Après maxLoginFailures échecs dans loginFailureWindow, la connexion est
+	// bloquée pendant loginLockout
+	maxLoginFailures   = 5
+	loginFailureWindow = 15 * time.Minute
+	loginLockout       = 15 * time.Minute
+)
+
+var ErrUsernameTaken = errors.New("username already taken")
+
+// Les profils antérieurs aux comptes enregistrés sont des invités
+func (user *UserProfile) IsGuest() bool {
+	return user.AccountType != AccountRegistered
+}
+
+// Réponse d'ouverture de session : le profil public et son jeton
+type SessionResponse struct {
+	ID          string      `json:"id"`
+	UserName    string      `json:"username"`
+	IsOnline    bool        `json:"isnOline"`
+	IsInRoom    bool        `json:"isInRoom"`
+	AccountType AccountType `json:"accountType"`
+	Token       string      `json:"token"`
+	ExpiresAt   int64       `json:"expiresAt"`
+}
+
+// presence est la présence actuelle de l'utilisateur, hors ligne pour un nouveau compte
+func writeSession(w http.ResponseWriter, status int, tokenAuthority *TokenAuthority, user *UserProfile, presence Presence) {
+	token, claims := tokenAuthority.Issue(user)
+	accountType := AccountGuest
+	if !user.IsGuest() {
+		accountType = AccountRegistered
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(SessionResponse{
+		ID:          user.ID,
+		UserName:    user.UserName,
+		IsOnline:    presence.Online,
+		IsInRoom:    presence.InRoom,
+		AccountType: accountType,
+		Token:       token,
+		ExpiresAt:   claims.ExpiresAt,
+	})
+}
+
+func validateUsername(username string) error {
+	length := len([]rune(username))
+	if length < minUsernameLength || length > maxUsernameLength {
+		return fmt.Errorf("username must be %d to %d characters", minUsernameLength, maxUsernameLength)
+	}
+	for _, r := range username {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) && r != '_' && r != '-' && r != '.' {
+			return fmt.Errorf("username may only contain letters, digits, '_', '-' and '.'")
+		}
+	}
+	return nil
+}
+
+func validatePassword(password string) error {
+	if len([]rune(password)) < minPasswordLength {
+		return fmt.Errorf("password must be at least %d characters", minPasswordLength)
+	}
+	if len(password) > maxPasswordLength {
+		return fmt.Errorf("password must be at most %d bytes", maxPasswordLength)
+	}
+	return nil
+}
+
+func hashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", fmt.Errorf("failed to hash password: %v", err)
+	}
+	return string(hash), nil
+}
+
+func (user *UserProfile) checkPassword(password string) bool {
+	if user.IsGuest() || user.PasswordHash == "" {
+		return false
+	}
+	return bcrypt.CompareHashAndPassword([]byte(user.PasswordHash), []byte(password)) == nil
+}
+
+// Crée un compte seulement si le nom est libre
+func (us *UserStore) RegisterUser(user UserProfile) error {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	if _, exists := us.Users[user.UserName]; exists {
+		return ErrUsernameTaken
+	}
+	us.Users[user.UserName] = user
+	return us.repository.Save(user)
+}
+
+func (us *UserStore) SetPassword(username, passwordHash string) error {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	user, exists := us.Users[username]
+	if !exists {
+		return fmt.Errorf("user not found")
+	}
+	user.PasswordHash = passwordHash
+	user.PasswordChangedAt = time.Now()
+	us.Users[username] = user
+	return us.repository.Save(user)
+}
+
+// Échecs de connexion récents pour un nom d'utilisateur ou une adresse
+type loginAttempts struct {
+	failures    []time.Time
+	lockedUntil time.Time
+}
+
+type LoginThrottler struct {
+	attempts map[string]*loginAttempts
+	mutex    sync.Mutex
+}
+
+func NewLoginThrottler() *LoginThrottler {
+	return &LoginThrottler{attempts: make(map[string]*loginAttempts)}
+}
+
+// Temps restant avant de pouvoir retenter une connexion, 0 si autorisée
+func (lt *LoginThrottler) RetryAfter(keys ...string) time.Duration {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	now := time.Now()
+	var wait time.Duration
+	for _, key := range keys {
+		if attempts, exists := lt.attempts[key]; exists && now.Before(attempts.lockedUntil) {
+			if remaining := attempts.lockedUntil.Sub(now); remaining > wait {
+				wait = remaining
+			}
+		}
+	}
+	return wait
+}
+
+func (lt *LoginThrottler) RecordFailure(keys ...string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	now := time.Now()
+	for _, key := range keys {
+		attempts, exists := lt.attempts[key]
+		if !exists {
+			attempts = &loginAttempts{}
+			lt.attempts[key] = attempts
+		}
+		recent := attempts.failures[:0]
+		for _, failure := range attempts.failures {
+			if now.Sub(failure) < loginFailureWindow {
+				recent = append(recent, failure)
+			}
+		}
+		attempts.failures = append(recent, now)
+		if len(attempts.failures) >= maxLoginFailures {
+			attempts.lockedUntil = now.Add(loginLockout)
+			attempts.failures = nil
+		}
+	}
+
+	// Oublier les entrées expirées
+	for key, attempts := range lt.attempts {
+		if len(attempts.failures) == 0 && now.After(attempts.lockedUntil) {
+			delete(lt.attempts, key)
+		}
+	}
+}
+
+func (lt *LoginThrottler) RecordSuccess(keys ...string) {
+	lt.mutex.Lock()
+	defer lt.mutex.Unlock()
+
+	for _, key := range keys {
+		delete(lt.attempts, key)
+	}
+}
+
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+type credentials struct {
+	UserName string `json:"username"`
+	Password string `json:"password"`
+}
+
+func RegisterHandler(userStore *UserStore, tokenAuthority *TokenAuthority) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input credentials
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		input.UserName = strings.TrimSpace(input.UserName)
+		if err := validateUsername(input.UserName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := validatePassword(input.Password); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		passwordHash, err := hashPassword(input.Password)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		newUser := UserProfile{
+			ID:                GenerateUniqueID(),
+			UserName:          input.UserName,
+			AccountType:       AccountRegistered,
+			PasswordHash:      passwordHash,
+			PasswordChangedAt: time.Now(),
+		}
+		if err := userStore.RegisterUser(newUser); errors.Is(err, ErrUsernameTaken) {
+			http.Error(w, err.Error(), http.StatusConflict)
+			return
+		} else if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		log.Printf("Registered account %s", newUser.UserName)
+		writeSession(w, http.StatusCreated, tokenAuthority, &newUser, Presence{Username: newUser.UserName, Status: PresenceOffline})
+	}
+}
+
+func LoginHandler(userStore *UserStore, tokenAuthority *TokenAuthority, throttler *LoginThrottler, presence *PresenceService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var input credentials
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		input.UserName = strings.TrimSpace(input.UserName)
+
+		keys := []string{"user:" + strings.ToLower(input.UserName), "ip:" + clientIP(r)}
+		if wait := throttler.RetryAfter(keys...); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			http.Error(w, "Too many failed login attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+
+		// Même réponse pour un compte inconnu ou un mauvais mot de passe
+		user, err := userStore.GetUser(input.UserName)
+		if err != nil || !user.checkPassword(input.Password) {
+			throttler.RecordFailure(keys...)
+			http.Error(w, "Invalid username or password", http.StatusUnauthorized)
+			return
+		}
+		throttler.RecordSuccess(keys...)
+
+		writeSession(w, http.StatusOK, tokenAuthority, user, presence.Get(user.UserName))
+	}
+}
+
+// Change le mot de passe et renvoie une nouvelle session, les anciennes
+// étant révoquées
+func ChangePasswordHandler(userStore *UserStore, tokenAuthority *TokenAuthority, throttler *LoginThrottler, presence *PresenceService) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, ok := AuthenticatedUser(r)
+		if !ok {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
+
+		var input struct {
+			CurrentPassword string `json:"currentPassword"`
+			NewPassword     string `json:"newPassword"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&input); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		user, err := userStore.GetUser(claims.Username)
+		if err != nil {
+			http.Error(w, "User not found", http.StatusNotFound)
+			return
+		}
+		if user.IsGuest() {
+			http.Error(w, "Guest accounts have no password", http.StatusForbidden)
+			return
+		}
+
+		keys := []string{"user:" + strings.ToLower(user.UserName)}
+		if wait := throttler.RetryAfter(keys...); wait > 0 {
+			w.Header().Set("Retry-After", strconv.Itoa(int(wait/time.Second)+1))
+			http.Error(w, "Too many failed attempts, try again later", http.StatusTooManyRequests)
+			return
+		}
+		if !user.checkPassword(input.CurrentPassword) {
+			throttler.RecordFailure(keys...)
+			http.Error(w, "Current password is incorrect", http.StatusUnauthorized)
+			return
+		}
+		throttler.RecordSuccess(keys...)
+
+		if err := validatePassword(input.NewPassword); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		passwordHash, err := hashPassword(input.NewPassword)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if err := userStore.SetPassword(user.UserName, passwordHash); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		updated, err := userStore.GetUser(user.UserName)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writeSession(w, http.StatusOK, tokenAuthority, updated, presence.Get(updated.UserName))
+	}
+}
@@ -0,0 +1,336 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultGamesPageSize = 20
+	maxGamesPageSize     = 100
+)
+
+// Partie archivée, telle que conservée après la fermeture de la room
+type GameRecord struct {
+	ID          string         `json:"id"`
+	WhitePlayer OnlineUser     `json:"white_player"`
+	BlackPlayer OnlineUser     `json:"black_player"`
+	CreatedAt   time.Time      `json:"created_at"`
+	EndedAt     time.Time      `json:"ended_at"`
+	TimeControl string         `json:"time_control"`
+	Result      string         `json:"result"`
+	Termination GameOverReason `json:"termination"`
+	WinnerID    string         `json:"winner_id,omitempty"`
+	StartFEN    string         `json:"start_fen"`
+	FinalFEN    string         `json:"final_fen"`
+	WhiteTimeMs int64          `json:"white_time_ms"`
+	BlackTimeMs int64          `json:"black_time_ms"`
+	Moves       []Move         `json:"moves"`
+	Chat        []ChatMessage  `json:"chat,omitempty"`
+}
+
+// Résumé d'une partie pour les listes paginées
+type GameSummary struct {
+	ID          string         `json:"id"`
+	White       string         `json:"white"`
+	Black       string         `json:"black"`
+	CreatedAt   time.Time      `json:"created_at"`
+	EndedAt     time.Time      `json:"ended_at"`
+	TimeControl string         `json:"time_control"`
+	Result      string         `json:"result"`
+	Termination GameOverReason `json:"termination"`
+	MoveCount   int            `json:"move_count"`
+}
+
+func (g *GameRecord) Summary() GameSummary {
+	return GameSummary{
+		ID:          g.ID,
+		White:       g.WhitePlayer.Username,
+		Black:       g.BlackPlayer.Username,
+		CreatedAt:   g.CreatedAt,
+		EndedAt:     g.EndedAt,
+		TimeControl: g.TimeControl,
+		Result:      g.Result,
+		Termination: g.Termination,
+		MoveCount:   len(g.Moves),
+	}
+}
+
+// Longueur maximale d'un identifiant de partie
+const maxGameIDLength = 128
+
+var ErrGameExists = errors.New("game already archived")
+
+type GameRepository interface {
+	// Save refuse d'écraser une partie déjà archivée
+	Save(record *GameRecord) error
+	Exists(id string) bool
+	Get(id string) (*GameRecord, error)
+	ListByUser(username string, offset, limit int) ([]GameSummary, int, error)
+}
+
+// Stockage des parties sur disque : un fichier JSON par partie dans le
+// dossier games, avec un index en mémoire des résumés
+type FileGameRepository struct {
+	dir       string
+	summaries map[string]GameSummary
+	mutex     sync.RWMutex
+}
+
+func NewFileGameRepository(dir string) *FileGameRepository {
+	return &FileGameRepository{
+		dir:       dir,
+		summaries: make(map[string]GameSummary),
+	}
+}
+
+func (repo *FileGameRepository) Load() error {
+	if err := os.MkdirAll(repo.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create games directory: %v", err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(repo.dir, "*.json"))
+	if err != nil {
+		return fmt.Errorf("failed to list games: %v", err)
+	}
+
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, file := range files {
+		record, err := readGameRecord(file)
+		if err != nil {
+			log.Printf("Warning: skipping unreadable game file %s: %v", file, err)
+			continue
+		}
+		repo.summaries[record.ID] = record.Summary()
+	}
+	return nil
+}
+
+func readGameRecord(filename string) (*GameRecord, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	var record GameRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, err
+	}
+	return &record, nil
+}
+
+// Un identifiant de partie sert de nom de fichier : il ne doit désigner
+// aucun autre chemin, pour que deux parties ne partagent jamais un fichier
+func validGameID(id string) bool {
+	if id == "" || len(id) > maxGameIDLength || strings.HasPrefix(id, ".") {
+		return false
+	}
+	return !strings.ContainsAny(id, `/\`)
+}
+
+func (repo *FileGameRepository) path(id string) string {
+	return filepath.Join(repo.dir, id+".json")
+}
+
+func (repo *FileGameRepository) Save(record *GameRecord) error {
+	data, err := json.MarshalIndent(record, "", "    ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal game: %v", err)
+	}
+
+	if !validGameID(record.ID) {
+		return fmt.Errorf("invalid game id %q", record.ID)
+	}
+
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	if _, exists := repo.summaries[record.ID]; exists {
+		return ErrGameExists
+	}
+	if err := writeFileAtomic(repo.path(record.ID), data, 0644); err != nil {
+		return fmt.Errorf("failed to write game file: %v", err)
+	}
+	repo.summaries[record.ID] = record.Summary()
+	return nil
+}
+
+func (repo *FileGameRepository) Exists(id string) bool {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	_, exists := repo.summaries[id]
+	return exists
+}
+
+func (repo *FileGameRepository) Get(id string) (*GameRecord, error) {
+	repo.mutex.RLock()
+	defer repo.mutex.RUnlock()
+
+	if _, exists := repo.summaries[id]; !exists {
+		return nil, fmt.Errorf("game not found")
+	}
+	return readGameRecord(repo.path(id))
+}
+
+// Parties d'un joueur, de la plus récente à la plus ancienne
+func (repo *FileGameRepository) ListByUser(username string, offset, limit int) ([]GameSummary, int, error) {
+	repo.mutex.RLock()
+	games := make([]GameSummary, 0)
+	for _, summary := range repo.summaries {
+		if summary.White == username || summary.Black == username {
+			games = append(games, summary)
+		}
+	}
+	repo.mutex.RUnlock()
+
+	sort.Slice(games, func(i, j int) bool {
+		return games[i].EndedAt.After(games[j].EndedAt)
+	})
+
+	total := len(games)
+	if offset >= total {
+		return []GameSummary{}, total, nil
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return games[offset:end], total, nil
+}
+
+func SetupGameRepository() GameRepository {
+	repository := NewFileGameRepository(Getenv("GAMES_DIR", "games"))
+	if err := repository.Load(); err != nil {
+		log.Printf("Warning: Error loading game archive: %v", err)
+	}
+	return repository
+}
+
+// Photographie de la room. La discussion n'y figure pas : une partie en cours
+// est visible de tous, et le canal des spectateurs est caché aux joueurs.
+// Seule l'archive écrite en fin de partie la conserve.
+func (room *ChessGameRoom) Record() *GameRecord {
+	timeControl := "-"
+	var whiteTimeMs, blackTimeMs int64
+	if room.Timer != nil {
+		timeControl = room.Timer.TimeControl().String()
+		white, black := room.Timer.Remaining()
+		whiteTimeMs = white.Milliseconds()
+		blackTimeMs = black.Milliseconds()
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	record := &GameRecord{
+		ID:          room.RoomID,
+		WhitePlayer: room.WhitePlayer,
+		BlackPlayer: room.BlackPlayer,
+		CreatedAt:   room.CreatedAt,
+		EndedAt:     room.EndedAt,
+		TimeControl: timeControl,
+		Result:      "*",
+		Termination: room.Termination,
+		WinnerID:    room.WinnerID,
+		StartFEN:    StartingFEN,
+		FinalFEN:    room.PositionFEN,
+		WhiteTimeMs: whiteTimeMs,
+		BlackTimeMs: blackTimeMs,
+		Moves:       append([]Move{}, room.Moves...),
+	}
+	if room.IsGameOver && room.Result != "" {
+		record.Result = room.Result
+	}
+	return record
+}
+
+// Archive une room sur le point d'être supprimée. Une partie interrompue sans
+// résultat est enregistrée comme abandonnée si des coups ont été joués.
+func (rm *RoomManager) archiveRoom(room *ChessGameRoom) {
+	if rm.onlineManager == nil || rm.onlineManager.gameRepository == nil {
+		return
+	}
+
+	record := room.Record()
+	room.mutex.RLock()
+	record.Chat = append([]ChatMessage{}, room.ChatLog...)
+	room.mutex.RUnlock()
+	if record.Termination == "" {
+		if len(record.Moves) == 0 {
+			return
+		}
+		record.Termination = ReasonAbandoned
+		record.EndedAt = time.Now()
+	}
+
+	if err := rm.onlineManager.gameRepository.Save(record); err != nil {
+		log.Printf("Error archiving game %s: %v", record.ID, err)
+	}
+}
+
+// Retrouve une partie en cours ou archivée
+func (m *OnlineUsersManager) findGame(gameID string) (*GameRecord, error) {
+	if room, exists := m.roomManager.GetRoom(gameID); exists {
+		return room.Record(), nil
+	}
+	return m.gameRepository.Get(gameID)
+}
+
+func GetGameHandler(onlineUsersManager *OnlineUsersManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		game, err := onlineUsersManager.findGame(mux.Vars(r)["id"])
+		if err != nil {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(game)
+	}
+}
+
+func ListUserGamesHandler(onlineUsersManager *OnlineUsersManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		username := mux.Vars(r)["name"]
+
+		page, err := strconv.Atoi(r.URL.Query().Get("page"))
+		if err != nil || page < 1 {
+			page = 1
+		}
+		limit, err := strconv.Atoi(r.URL.Query().Get("limit"))
+		if err != nil || limit < 1 {
+			limit = defaultGamesPageSize
+		}
+		if limit > maxGamesPageSize {
+			limit = maxGamesPageSize
+		}
+
+		games, total, err := onlineUsersManager.gameRepository.ListByUser(username, (page-1)*limit, limit)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"games": games,
+			"page":  page,
+			"limit": limit,
+			"total": total,
+		})
+	}
+}
@@ -0,0 +1,56 @@
+package service
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestFileGameRepositoryRefusesOverwrite(t *testing.T) {
+	repo := NewFileGameRepository(t.TempDir())
+	if err := repo.Load(); err != nil {
+		t.Fatalf("Load: %v", err)
+	}
+
+	original := &GameRecord{ID: "abc", Result: "1-0", WhitePlayer: OnlineUser{Username: "alice"}}
+	if err := repo.Save(original); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if !repo.Exists("abc") {
+		t.Fatal("saved game does not exist")
+	}
+
+	forged := &GameRecord{ID: "abc", Result: "0-1", WhitePlayer: OnlineUser{Username: "mallory"}}
+	if err := repo.Save(forged); !errors.Is(err, ErrGameExists) {
+		t.Errorf("Save over an archived game: err = %v, want ErrGameExists", err)
+	}
+	for _, id := range []string{"x/abc", `x\abc`, "../abc", ".abc", ""} {
+		if err := repo.Save(&GameRecord{ID: id}); err == nil {
+			t.Errorf("Save(%q) succeeded, want an error", id)
+		}
+	}
+
+	stored, err := repo.Get("abc")
+	if err != nil {
+		t.Fatalf("Get: %v", err)
+	}
+	if stored.Result != "1-0" || stored.WhitePlayer.Username != "alice" {
+		t.Errorf("archived game was replaced: %+v", stored)
+	}
+}
+
+func TestCheckRoomIDRejectsArchivedGames(t *testing.T) {
+	m := newTestManager(t)
+	if err := m.gameRepository.Save(&GameRecord{ID: "finished"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+
+	if err := m.roomManager.CheckRoomID("finished"); err == nil {
+		t.Error("CheckRoomID accepted an archived game id")
+	}
+	if _, err := m.roomManager.CreateRoom(InvitationMessage{RoomID: "finished"}); err == nil {
+		t.Error("CreateRoom reused an archived game id")
+	}
+	if err := m.roomManager.CheckRoomID("fresh"); err != nil {
+		t.Errorf("CheckRoomID(fresh) = %v", err)
+	}
+}
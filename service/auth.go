@@ -0,0 +1,159 @@
+package service
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Contenu signé d'un jeton de session
+type TokenClaims struct {
+	UserID    string `json:"uid"`
+	Username  string `json:"sub"`
+	IssuedAt  int64  `json:"iat"`
+	ExpiresAt int64  `json:"exp"`
+}
+
+// Émet et vérifie les jetons de session signés par HMAC-SHA256.
+// Format : base64url(claims JSON) "." base64url(signature)
+type TokenAuthority struct {
+	secret []byte
+	ttl    time.Duration
+}
+
+type authContextKey struct{}
+
+func NewTokenAuthority(secret []byte, ttl time.Duration) *TokenAuthority {
+	return &TokenAuthority{secret: secret, ttl: ttl}
+}
+
+// AUTH_SECRET signe les jetons ; sans lui, un secret aléatoire est généré et
+// les jetons ne survivent pas à un redémarrage. AUTH_TOKEN_TTL_HOURS fixe leur durée.
+func SetupTokenAuthority() *TokenAuthority {
+	secret := []byte(Getenv("AUTH_SECRET", ""))
+	if len(secret) == 0 {
+		secret = make([]byte, 32)
+		if _, err := rand.Read(secret); err != nil {
+			log.Fatalf("Failed to generate auth secret: %v", err)
+		}
+		log.Printf("Warning: AUTH_SECRET not set, using a random secret (sessions will not survive a restart)")
+	}
+
+	hours, err := strconv.Atoi(Getenv("AUTH_TOKEN_TTL_HOURS", "24"))
+	if err != nil || hours < 1 {
+		hours = 24
+	}
+	return NewTokenAuthority(secret, time.Duration(hours)*time.Hour)
+}
+
+func (ta *TokenAuthority) sign(payload string) string {
+	mac := hmac.New(sha256.New, ta.secret)
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (ta *TokenAuthority) Issue(user *UserProfile) (string, TokenClaims) {
+	now := time.Now()
+	claims := TokenClaims{
+		UserID:    user.ID,
+		Username:  user.UserName,
+		IssuedAt:  now.Unix(),
+		ExpiresAt: now.Add(ta.ttl).Unix(),
+	}
+	payload := base64.RawURLEncoding.EncodeToString(mustJson(claims))
+	return payload + "." + ta.sign(payload), claims
+}
+
+func (ta *TokenAuthority) Verify(token string) (TokenClaims, error) {
+	payload, signature, found := strings.Cut(token, ".")
+	if !found {
+		return TokenClaims{}, fmt.Errorf("malformed token")
+	}
+	if !hmac.Equal([]byte(signature), []byte(ta.sign(payload))) {
+		return TokenClaims{}, fmt.Errorf("invalid token signature")
+	}
+
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		return TokenClaims{}, fmt.Errorf("malformed token")
+	}
+	var claims TokenClaims
+	if err := json.Unmarshal(data, &claims); err != nil {
+		return TokenClaims{}, fmt.Errorf("malformed token")
+	}
+	if time.Now().Unix() >= claims.ExpiresAt {
+		return TokenClaims{}, fmt.Errorf("token expired")
+	}
+	return claims, nil
+}
+
+// Le jeton est lu dans l'en-tête Authorization ("Bearer <token>")
+func tokenFromHeader(r *http.Request) string {
+	if header := r.Header.Get("Authorization"); strings.HasPrefix(header, "Bearer ") {
+		return strings.TrimSpace(strings.TrimPrefix(header, "Bearer "))
+	}
+	return ""
+}
+
+// Vérifie le jeton de la requête et que le compte existe toujours
+func (ta *TokenAuthority) Authenticate(r *http.Request, userStore *UserStore) (TokenClaims, error) {
+	return ta.authenticateToken(tokenFromHeader(r), userStore)
+}
+
+// Comme Authenticate, en acceptant aussi le paramètre token : les WebSockets
+// ne peuvent pas envoyer d'en-tête. Réservé à /ws, pour que les jetons
+// n'apparaissent pas dans les URL des appels REST.
+func (ta *TokenAuthority) AuthenticateWebSocket(r *http.Request, userStore *UserStore) (TokenClaims, error) {
+	token := tokenFromHeader(r)
+	if token == "" {
+		token = r.URL.Query().Get("token")
+	}
+	return ta.authenticateToken(token, userStore)
+}
+
+func (ta *TokenAuthority) authenticateToken(token string, userStore *UserStore) (TokenClaims, error) {
+	if token == "" {
+		return TokenClaims{}, fmt.Errorf("authentication required")
+	}
+	claims, err := ta.Verify(token)
+	if err != nil {
+		return TokenClaims{}, err
+	}
+	user, err := userStore.GetUser(claims.Username)
+	if err != nil || user.ID != claims.UserID {
+		return TokenClaims{}, fmt.Errorf("unknown user")
+	}
+	// Un changement de mot de passe révoque les sessions ouvertes avant lui
+	if !user.PasswordChangedAt.IsZero() && claims.IssuedAt < user.PasswordChangedAt.Unix() {
+		return TokenClaims{}, fmt.Errorf("session revoked")
+	}
+	return claims, nil
+}
+
+// Middleware réservant un handler aux requêtes authentifiées
+func RequireAuth(ta *TokenAuthority, userStore *UserStore, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		claims, err := ta.Authenticate(r, userStore)
+		if err != nil {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, err.Error(), http.StatusUnauthorized)
+			return
+		}
+		next(w, r.WithContext(context.WithValue(r.Context(), authContextKey{}, claims)))
+	}
+}
+
+// Identité authentifiée par RequireAuth
+func AuthenticatedUser(r *http.Request) (TokenClaims, bool) {
+	claims, ok := r.Context().Value(authContextKey{}).(TokenClaims)
+	return claims, ok
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"errors"
+	"log"
+
+	"chess_backend/protocol"
+)
+
+// Erreur accompagnée de détails pour le client (partie, position courante...)
+type detailedError struct {
+	err     error
+	details map[string]interface{}
+}
+
+func (e *detailedError) Error() string {
+	return e.err.Error()
+}
+
+func (e *detailedError) Unwrap() error {
+	return e.err
+}
+
+func withDetails(err error, details map[string]interface{}) error {
+	return &detailedError{err: err, details: details}
+}
+
+// Vérifie qu'un message peut être émis par l'utilisateur de la connexion.
+// Les champs d'identité du payload ne sont jamais crus : ils doivent
+// correspondre à la connexion, et les rooms visées doivent être celles du joueur.
+func (m *OnlineUsersManager) authorize(session *clientSession, msgType string, payload protocol.Payload) error {
+	username := session.username
+
+	switch p := payload.(type) {
+	case *protocol.InvitationSend:
+		if p.FromUsername != "" && p.FromUsername != username {
+			return protocol.NewError(protocol.CodeForbidden, "invitations can only be sent in your own name")
+		}
+		if p.ToUsername == username {
+			return protocol.NewError(protocol.CodeForbidden, "cannot invite yourself")
+		}
+
+	case *protocol.InvitationReply:
+		tempRoom, exists := m.tempRoomManager.GetTempRoom(p.RoomID)
+		if !exists {
+			return protocol.NewError(protocol.CodeRoomNotFound, "invitation not found")
+		}
+		if msgType == protocol.TypeInvitationCancel {
+			if (p.FromUsername != "" && p.FromUsername != username) || tempRoom.WhitePlayer.Username != username {
+				return protocol.NewError(protocol.CodeForbidden, "only the sender can cancel an invitation")
+			}
+			return nil
+		}
+		if p.ToUsername != "" && p.ToUsername != username {
+			return protocol.NewError(protocol.CodeForbidden, "cannot answer an invitation for another user")
+		}
+		if tempRoom.BlackPlayer.Username != username {
+			return protocol.NewError(protocol.CodeNotInvited, "this invitation is not addressed to you")
+		}
+
+	case *protocol.RoomLeave:
+		if (p.FromUsername != "" && p.FromUsername != username) || (p.Username != "" && p.Username != username) {
+			return protocol.NewError(protocol.CodeForbidden, "cannot leave a room for another user")
+		}
+		if roomID := p.Room(); roomID != "" {
+			if _, err := m.playerRoom(username, roomID); err != nil {
+				return err
+			}
+		}
+
+	case *protocol.GameMove:
+		room, err := m.playerRoom(username, p.GameID)
+		if err != nil {
+			return err
+		}
+		if p.ToUsername != "" {
+			if opponent, _ := room.GetOtherPlayer(username); p.ToUsername != opponent {
+				return protocol.NewError(protocol.CodeForbidden, "moves can only be sent to your opponent")
+			}
+		}
+		if p.FromUserID != "" {
+			if user, err := m.userStore.GetUser(username); err == nil && user.ID != p.FromUserID {
+				return protocol.NewError(protocol.CodeForbidden, "fromUserId does not match the connected user")
+			}
+		}
+
+	case *protocol.GameOverClaim:
+		if _, err := m.playerRoom(username, p.GameID); err != nil {
+			return err
+		}
+
+	case *protocol.GameAction:
+		// Les spectateurs visent une partie dont ils ne sont pas joueurs
+		if msgType == protocol.TypeSpectateJoin {
+			return nil
+		}
+		if _, err := m.playerRoom(username, p.GameID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Room dont l'utilisateur est l'un des joueurs
+func (m *OnlineUsersManager) playerRoom(username, gameID string) (*ChessGameRoom, error) {
+	room, exists := m.roomManager.GetRoom(gameID)
+	if !exists {
+		return nil, protocol.NewError(protocol.CodeRoomNotFound, "room not found")
+	}
+	if !room.isPlayer(username) {
+		return nil, protocol.NewError(protocol.CodeNotInRoom, "you are not a player in this game")
+	}
+	return room, nil
+}
+
+// Envoie un message d'erreur typé au client, en réponse au message id
+func sendError(conn *SafeConn, id string, err error) {
+	content := map[string]interface{}{
+		"code":    protocol.ErrorCode(err),
+		"message": err.Error(),
+	}
+	var detailed *detailedError
+	if errors.As(err, &detailed) {
+		for key, value := range detailed.details {
+			content[key] = value
+		}
+	}
+	if writeErr := conn.WriteJSON(WebSocketMessage{
+		Type:    protocol.TypeError,
+		ID:      id,
+		Content: string(mustJson(content)),
+	}); writeErr != nil {
+		log.Printf("Error sending error message: %v", writeErr)
+	}
+}
+
+// Confirme au client que le message id a été traité
+func sendAck(conn *SafeConn, id string, msgType string) {
+	if err := conn.WriteJSON(WebSocketMessage{
+		Type:    protocol.TypeAck,
+		ID:      id,
+		Content: string(mustJson(protocol.Ack{Type: msgType})),
+	}); err != nil {
+		log.Printf("Error sending ack: %v", err)
+	}
+}
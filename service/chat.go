@@ -0,0 +1,202 @@
+package service
+
+import (
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+
+	"chess_backend/protocol"
+)
+
+const ChatMessageType string = "chat_message"
+
+type ChatChannel string
+
+const (
+	// Discussion entre les deux joueurs
+	ChatChannelPlayers ChatChannel = "players"
+	// Discussion entre spectateurs, invisible pour les joueurs
+	ChatChannelSpectators ChatChannel = "spectators"
+)
+
+const (
+	maxChatMessageLength = 300
+	maxChatLogSize       = 200
+	// Anti-flood : au plus chatFloodLimit messages par chatFloodWindow
+	chatFloodLimit  = 5
+	chatFloodWindow = 10 * time.Second
+)
+
+// Mots masqués dans les messages ; CHAT_BANNED_WORDS permet d'en ajouter
+// (liste séparée par des virgules)
+var defaultBannedWords = []string{
+	"fuck", "shit", "bitch", "asshole", "bastard", "cunt",
+	"merde", "putain", "connard", "connasse", "salope", "encule",
+}
+
+type ChatMessage struct {
+	Channel ChatChannel `json:"channel"`
+	From    string      `json:"from"`
+	Text    string      `json:"text"`
+	SentAt  time.Time   `json:"sentAt"`
+}
+
+func bannedWords() []string {
+	words := append([]string{}, defaultBannedWords...)
+	for _, word := range strings.Split(Getenv("CHAT_BANNED_WORDS", ""), ",") {
+		if word = strings.TrimSpace(strings.ToLower(word)); word != "" {
+			words = append(words, word)
+		}
+	}
+	return words
+}
+
+// Remplace chaque mot interdit par des astérisques, sans tenir compte de la casse
+func censorChat(text string) string {
+	words := bannedWords()
+	runes := []rune(text)
+	lower := []rune(strings.ToLower(text))
+	if len(lower) != len(runes) {
+		return text
+	}
+
+	isWordChar := func(i int) bool {
+		return i >= 0 && i < len(lower) && (unicode.IsLetter(lower[i]) || unicode.IsDigit(lower[i]))
+	}
+	for _, word := range words {
+		target := []rune(word)
+		for i := 0; i+len(target) <= len(lower); i++ {
+			if string(lower[i:i+len(target)]) != word {
+				continue
+			}
+			// Seuls les mots entiers sont masqués
+			if isWordChar(i-1) || isWordChar(i+len(target)) {
+				continue
+			}
+			for j := i; j < i+len(target); j++ {
+				runes[j] = '*'
+			}
+		}
+	}
+	return string(runes)
+}
+
+// Nettoie et valide le texte d'un message
+func normalizeChatText(text string) (string, error) {
+	text = strings.TrimSpace(strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, text))
+	if text == "" {
+		return "", protocol.NewError(protocol.CodeInvalidPayload, "empty message")
+	}
+	if utf8.RuneCountInString(text) > maxChatMessageLength {
+		return "", protocol.Errorf(protocol.CodeInvalidPayload, "message too long (max %d characters)", maxChatMessageLength)
+	}
+	return censorChat(text), nil
+}
+
+// Ajoute un message au journal de la room. Le canal dépend du rôle de
+// l'expéditeur : les joueurs parlent entre eux, les spectateurs entre eux.
+func (room *ChessGameRoom) PostChat(username, text string) (ChatMessage, error) {
+	text, err := normalizeChatText(text)
+	if err != nil {
+		return ChatMessage{}, err
+	}
+
+	now := time.Now()
+
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	channel := ChatChannelPlayers
+	if !room.isPlayer(username) {
+		if _, spectating := room.spectators[username]; !spectating {
+			return ChatMessage{}, protocol.NewError(protocol.CodeNotInRoom, "you are not in this game")
+		}
+		channel = ChatChannelSpectators
+	}
+
+	// Anti-flood : trop de messages récents, ou répétition du dernier message
+	recent := room.chatActivity[username][:0]
+	for _, sentAt := range room.chatActivity[username] {
+		if now.Sub(sentAt) < chatFloodWindow {
+			recent = append(recent, sentAt)
+		}
+	}
+	if len(recent) >= chatFloodLimit {
+		room.chatActivity[username] = recent
+		return ChatMessage{}, protocol.NewError(protocol.CodeRateLimited, "you are sending messages too fast")
+	}
+	for i := len(room.ChatLog) - 1; i >= 0; i-- {
+		if room.ChatLog[i].From == username {
+			if room.ChatLog[i].Text == text && now.Sub(room.ChatLog[i].SentAt) < chatFloodWindow {
+				return ChatMessage{}, protocol.NewError(protocol.CodeRateLimited, "duplicate message")
+			}
+			break
+		}
+	}
+	room.chatActivity[username] = append(recent, now)
+
+	message := ChatMessage{
+		Channel: channel,
+		From:    username,
+		Text:    text,
+		SentAt:  now,
+	}
+	room.ChatLog = append(room.ChatLog, message)
+	if len(room.ChatLog) > maxChatLogSize {
+		room.ChatLog = append([]ChatMessage{}, room.ChatLog[len(room.ChatLog)-maxChatLogSize:]...)
+	}
+	return message, nil
+}
+
+// Messages d'un canal, du plus ancien au plus récent
+func (room *ChessGameRoom) ChatHistory(channel ChatChannel) []ChatMessage {
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	history := make([]ChatMessage, 0)
+	for _, message := range room.ChatLog {
+		if message.Channel == channel {
+			history = append(history, message)
+		}
+	}
+	return history
+}
+
+// Diffuse un message sur son canal
+func (room *ChessGameRoom) BroadcastChat(message ChatMessage) {
+	outgoing := WebSocketMessage{
+		Type: ChatMessageType,
+		Content: string(mustJson(map[string]interface{}{
+			"gameId":  room.RoomID,
+			"channel": message.Channel,
+			"from":    message.From,
+			"text":    message.Text,
+			"sentAt":  message.SentAt,
+		})),
+	}
+	if message.Channel == ChatChannelSpectators {
+		room.BroadcastToSpectators(outgoing)
+		return
+	}
+	room.BroadcastMessage(outgoing)
+}
+
+func (m *OnlineUsersManager) handleChatMessage(username, gameID, text string) error {
+	room, exists := m.roomManager.GetRoom(gameID)
+	if !exists {
+		return protocol.NewError(protocol.CodeRoomNotFound, "game not found")
+	}
+
+	message, err := room.PostChat(username, text)
+	if err != nil {
+		return err
+	}
+	room.BroadcastChat(message)
+	return nil
+}
@@ -0,0 +1,760 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Position de départ standard
+const StartingFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
+
+type Color int8
+
+const (
+	White Color = iota
+	Black
+)
+
+func (c Color) Opponent() Color {
+	return c ^ 1
+}
+
+func (c Color) String() string {
+	if c == White {
+		return "white"
+	}
+	return "black"
+}
+
+type PieceType int8
+
+const (
+	NoPiece PieceType = iota
+	Pawn
+	Knight
+	Bishop
+	Rook
+	Queen
+	King
+)
+
+const pieceLetters = " pnbrqk"
+
+// Lettre minuscule de la pièce, telle qu'utilisée en UCI et en FEN
+func (pt PieceType) Letter() string {
+	if pt <= NoPiece || pt > King {
+		return ""
+	}
+	return string(pieceLetters[pt])
+}
+
+func parsePieceType(s string) PieceType {
+	switch strings.ToLower(strings.TrimSpace(s)) {
+	case "p", "pawn":
+		return Pawn
+	case "n", "knight":
+		return Knight
+	case "b", "bishop":
+		return Bishop
+	case "r", "rook":
+		return Rook
+	case "q", "queen":
+		return Queen
+	case "k", "king":
+		return King
+	}
+	return NoPiece
+}
+
+type Piece struct {
+	Type  PieceType
+	Color Color
+}
+
+func (p Piece) IsEmpty() bool {
+	return p.Type == NoPiece
+}
+
+func (p Piece) fenChar() byte {
+	c := pieceLetters[p.Type]
+	if p.Color == White {
+		return c - 'a' + 'A'
+	}
+	return c
+}
+
+// Les cases sont numérotées de 0 (a1) à 63 (h8)
+type Square int8
+
+const NoSquare Square = -1
+
+func newSquare(file, rank int) Square {
+	return Square(rank*8 + file)
+}
+
+func (s Square) File() int { return int(s) % 8 }
+func (s Square) Rank() int { return int(s) / 8 }
+
+func (s Square) String() string {
+	if s < 0 || s > 63 {
+		return "-"
+	}
+	return string([]byte{byte('a' + s.File()), byte('1' + s.Rank())})
+}
+
+func ParseSquare(s string) (Square, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if len(s) != 2 || s[0] < 'a' || s[0] > 'h' || s[1] < '1' || s[1] > '8' {
+		return NoSquare, fmt.Errorf("invalid square %q", s)
+	}
+	return newSquare(int(s[0]-'a'), int(s[1]-'1')), nil
+}
+
+// Décale une case, en signalant si on sort de l'échiquier
+func (s Square) offset(df, dr int) (Square, bool) {
+	f, r := s.File()+df, s.Rank()+dr
+	if f < 0 || f > 7 || r < 0 || r > 7 {
+		return NoSquare, false
+	}
+	return newSquare(f, r), true
+}
+
+// Droits de roque
+const (
+	castleWhiteKing uint8 = 1 << iota
+	castleWhiteQueen
+	castleBlackKing
+	castleBlackQueen
+)
+
+type ChessMove struct {
+	From      Square
+	To        Square
+	Piece     PieceType
+	Captured  PieceType
+	Promotion PieceType
+	EnPassant bool
+	Castle    bool
+}
+
+func (m ChessMove) UCI() string {
+	return m.From.String() + m.To.String() + m.Promotion.Letter()
+}
+
+type Position struct {
+	Board          [64]Piece
+	Turn           Color
+	Castling       uint8
+	EnPassant      Square
+	HalfmoveClock  int
+	FullmoveNumber int
+}
+
+var (
+	knightSteps  = [][2]int{{1, 2}, {2, 1}, {2, -1}, {1, -2}, {-1, -2}, {-2, -1}, {-2, 1}, {-1, 2}}
+	kingSteps    = [][2]int{{1, 0}, {1, 1}, {0, 1}, {-1, 1}, {-1, 0}, {-1, -1}, {0, -1}, {1, -1}}
+	bishopRays   = [][2]int{{1, 1}, {1, -1}, {-1, 1}, {-1, -1}}
+	rookRays     = [][2]int{{1, 0}, {-1, 0}, {0, 1}, {0, -1}}
+	promotionSet = []PieceType{Queen, Rook, Bishop, Knight}
+)
+
+func ParseFEN(fen string) (*Position, error) {
+	fields := strings.Fields(fen)
+	if len(fields) < 4 {
+		return nil, fmt.Errorf("invalid FEN %q: expected at least 4 fields", fen)
+	}
+
+	pos := &Position{EnPassant: NoSquare, FullmoveNumber: 1}
+
+	ranks := strings.Split(fields[0], "/")
+	if len(ranks) != 8 {
+		return nil, fmt.Errorf("invalid FEN %q: expected 8 ranks", fen)
+	}
+	kings := [2]int{}
+	for i, row := range ranks {
+		rank := 7 - i
+		file := 0
+		for _, c := range row {
+			if c >= '1' && c <= '8' {
+				file += int(c - '0')
+				continue
+			}
+			pt := parsePieceType(string(c))
+			if pt == NoPiece || file > 7 {
+				return nil, fmt.Errorf("invalid FEN %q: bad rank %q", fen, row)
+			}
+			color := Black
+			if c >= 'A' && c <= 'Z' {
+				color = White
+			}
+			if pt == King {
+				kings[color]++
+			}
+			pos.Board[newSquare(file, rank)] = Piece{Type: pt, Color: color}
+			file++
+		}
+		if file != 8 {
+			return nil, fmt.Errorf("invalid FEN %q: bad rank %q", fen, row)
+		}
+	}
+	if kings[White] != 1 || kings[Black] != 1 {
+		return nil, fmt.Errorf("invalid FEN %q: each side needs exactly one king", fen)
+	}
+
+	switch fields[1] {
+	case "w":
+		pos.Turn = White
+	case "b":
+		pos.Turn = Black
+	default:
+		return nil, fmt.Errorf("invalid FEN %q: bad side to move", fen)
+	}
+
+	if fields[2] != "-" {
+		for _, c := range fields[2] {
+			switch c {
+			case 'K':
+				pos.Castling |= castleWhiteKing
+			case 'Q':
+				pos.Castling |= castleWhiteQueen
+			case 'k':
+				pos.Castling |= castleBlackKing
+			case 'q':
+				pos.Castling |= castleBlackQueen
+			default:
+				return nil, fmt.Errorf("invalid FEN %q: bad castling rights", fen)
+			}
+		}
+	}
+
+	if fields[3] != "-" {
+		sq, err := ParseSquare(fields[3])
+		if err != nil {
+			return nil, fmt.Errorf("invalid FEN %q: bad en passant square", fen)
+		}
+		pos.EnPassant = sq
+	}
+
+	if len(fields) >= 6 {
+		halfmove, err1 := strconv.Atoi(fields[4])
+		fullmove, err2 := strconv.Atoi(fields[5])
+		if err1 != nil || err2 != nil || halfmove < 0 || fullmove < 1 {
+			return nil, fmt.Errorf("invalid FEN %q: bad move counters", fen)
+		}
+		pos.HalfmoveClock = halfmove
+		pos.FullmoveNumber = fullmove
+	}
+
+	return pos, nil
+}
+
+func (p *Position) FEN() string {
+	var sb strings.Builder
+	for rank := 7; rank >= 0; rank-- {
+		empty := 0
+		for file := 0; file < 8; file++ {
+			piece := p.Board[newSquare(file, rank)]
+			if piece.IsEmpty() {
+				empty++
+				continue
+			}
+			if empty > 0 {
+				sb.WriteByte(byte('0' + empty))
+				empty = 0
+			}
+			sb.WriteByte(piece.fenChar())
+		}
+		if empty > 0 {
+			sb.WriteByte(byte('0' + empty))
+		}
+		if rank > 0 {
+			sb.WriteByte('/')
+		}
+	}
+
+	if p.Turn == White {
+		sb.WriteString(" w ")
+	} else {
+		sb.WriteString(" b ")
+	}
+
+	castling := ""
+	if p.Castling&castleWhiteKing != 0 {
+		castling += "K"
+	}
+	if p.Castling&castleWhiteQueen != 0 {
+		castling += "Q"
+	}
+	if p.Castling&castleBlackKing != 0 {
+		castling += "k"
+	}
+	if p.Castling&castleBlackQueen != 0 {
+		castling += "q"
+	}
+	if castling == "" {
+		castling = "-"
+	}
+	sb.WriteString(castling)
+	sb.WriteByte(' ')
+	sb.WriteString(p.EnPassant.String())
+	fmt.Fprintf(&sb, " %d %d", p.HalfmoveClock, p.FullmoveNumber)
+	return sb.String()
+}
+
+func (p *Position) kingSquare(c Color) Square {
+	for sq := Square(0); sq < 64; sq++ {
+		if piece := p.Board[sq]; piece.Type == King && piece.Color == c {
+			return sq
+		}
+	}
+	return NoSquare
+}
+
+// Indique si la case est attaquée par une pièce de la couleur donnée
+func (p *Position) isAttacked(sq Square, by Color) bool {
+	// Pions : un pion blanc attaque en diagonale vers le haut
+	pawnRank := -1
+	if by == Black {
+		pawnRank = 1
+	}
+	for _, df := range []int{-1, 1} {
+		if from, ok := sq.offset(df, pawnRank); ok {
+			if piece := p.Board[from]; piece.Type == Pawn && piece.Color == by {
+				return true
+			}
+		}
+	}
+
+	for _, step := range knightSteps {
+		if from, ok := sq.offset(step[0], step[1]); ok {
+			if piece := p.Board[from]; piece.Type == Knight && piece.Color == by {
+				return true
+			}
+		}
+	}
+
+	for _, step := range kingSteps {
+		if from, ok := sq.offset(step[0], step[1]); ok {
+			if piece := p.Board[from]; piece.Type == King && piece.Color == by {
+				return true
+			}
+		}
+	}
+
+	if p.rayAttacked(sq, by, bishopRays, Bishop) || p.rayAttacked(sq, by, rookRays, Rook) {
+		return true
+	}
+	return false
+}
+
+func (p *Position) rayAttacked(sq Square, by Color, rays [][2]int, slider PieceType) bool {
+	for _, ray := range rays {
+		from := sq
+		for {
+			var ok bool
+			from, ok = from.offset(ray[0], ray[1])
+			if !ok {
+				break
+			}
+			piece := p.Board[from]
+			if piece.IsEmpty() {
+				continue
+			}
+			if piece.Color == by && (piece.Type == slider || piece.Type == Queen) {
+				return true
+			}
+			break
+		}
+	}
+	return false
+}
+
+func (p *Position) InCheck() bool {
+	return p.isAttacked(p.kingSquare(p.Turn), p.Turn.Opponent())
+}
+
+// Génère les coups pseudo-légaux (sans vérifier si le roi reste en échec)
+func (p *Position) pseudoMoves() []ChessMove {
+	moves := make([]ChessMove, 0, 64)
+	us := p.Turn
+
+	for from := Square(0); from < 64; from++ {
+		piece := p.Board[from]
+		if piece.IsEmpty() || piece.Color != us {
+			continue
+		}
+
+		switch piece.Type {
+		case Pawn:
+			moves = p.appendPawnMoves(moves, from)
+		case Knight:
+			moves = p.appendStepMoves(moves, from, Knight, knightSteps)
+		case Bishop:
+			moves = p.appendSlideMoves(moves, from, Bishop, bishopRays)
+		case Rook:
+			moves = p.appendSlideMoves(moves, from, Rook, rookRays)
+		case Queen:
+			moves = p.appendSlideMoves(moves, from, Queen, bishopRays)
+			moves = p.appendSlideMoves(moves, from, Queen, rookRays)
+		case King:
+			moves = p.appendStepMoves(moves, from, King, kingSteps)
+			moves = p.appendCastlingMoves(moves, from)
+		}
+	}
+	return moves
+}
+
+func (p *Position) appendPawnMoves(moves []ChessMove, from Square) []ChessMove {
+	us := p.Turn
+	dir, startRank, lastRank := 1, 1, 7
+	if us == Black {
+		dir, startRank, lastRank = -1, 6, 0
+	}
+
+	add := func(to Square, captured PieceType, enPassant bool) {
+		if to.Rank() == lastRank {
+			for _, promo := range promotionSet {
+				moves = append(moves, ChessMove{From: from, To: to, Piece: Pawn, Captured: captured, Promotion: promo})
+			}
+			return
+		}
+		moves = append(moves, ChessMove{From: from, To: to, Piece: Pawn, Captured: captured, EnPassant: enPassant})
+	}
+
+	if one, ok := from.offset(0, dir); ok && p.Board[one].IsEmpty() {
+		add(one, NoPiece, false)
+		if from.Rank() == startRank {
+			if two, ok := from.offset(0, 2*dir); ok && p.Board[two].IsEmpty() {
+				add(two, NoPiece, false)
+			}
+		}
+	}
+
+	for _, df := range []int{-1, 1} {
+		to, ok := from.offset(df, dir)
+		if !ok {
+			continue
+		}
+		target := p.Board[to]
+		if !target.IsEmpty() && target.Color != us {
+			add(to, target.Type, false)
+		} else if target.IsEmpty() && to == p.EnPassant {
+			add(to, Pawn, true)
+		}
+	}
+	return moves
+}
+
+func (p *Position) appendStepMoves(moves []ChessMove, from Square, pt PieceType, steps [][2]int) []ChessMove {
+	for _, step := range steps {
+		to, ok := from.offset(step[0], step[1])
+		if !ok {
+			continue
+		}
+		target := p.Board[to]
+		if target.IsEmpty() || target.Color != p.Turn {
+			moves = append(moves, ChessMove{From: from, To: to, Piece: pt, Captured: target.Type})
+		}
+	}
+	return moves
+}
+
+func (p *Position) appendSlideMoves(moves []ChessMove, from Square, pt PieceType, rays [][2]int) []ChessMove {
+	for _, ray := range rays {
+		to := from
+		for {
+			var ok bool
+			to, ok = to.offset(ray[0], ray[1])
+			if !ok {
+				break
+			}
+			target := p.Board[to]
+			if target.IsEmpty() {
+				moves = append(moves, ChessMove{From: from, To: to, Piece: pt})
+				continue
+			}
+			if target.Color != p.Turn {
+				moves = append(moves, ChessMove{From: from, To: to, Piece: pt, Captured: target.Type})
+			}
+			break
+		}
+	}
+	return moves
+}
+
+func (p *Position) appendCastlingMoves(moves []ChessMove, from Square) []ChessMove {
+	us := p.Turn
+	them := us.Opponent()
+	rank := 0
+	kingSide, queenSide := castleWhiteKing, castleWhiteQueen
+	if us == Black {
+		rank = 7
+		kingSide, queenSide = castleBlackKing, castleBlackQueen
+	}
+
+	if from != newSquare(4, rank) || p.isAttacked(from, them) {
+		return moves
+	}
+
+	rook := Piece{Type: Rook, Color: us}
+	if p.Castling&kingSide != 0 && p.Board[newSquare(7, rank)] == rook &&
+		p.Board[newSquare(5, rank)].IsEmpty() && p.Board[newSquare(6, rank)].IsEmpty() &&
+		!p.isAttacked(newSquare(5, rank), them) && !p.isAttacked(newSquare(6, rank), them) {
+		moves = append(moves, ChessMove{From: from, To: newSquare(6, rank), Piece: King, Castle: true})
+	}
+
+	if p.Castling&queenSide != 0 && p.Board[newSquare(0, rank)] == rook &&
+		p.Board[newSquare(1, rank)].IsEmpty() && p.Board[newSquare(2, rank)].IsEmpty() && p.Board[newSquare(3, rank)].IsEmpty() &&
+		!p.isAttacked(newSquare(2, rank), them) && !p.isAttacked(newSquare(3, rank), them) {
+		moves = append(moves, ChessMove{From: from, To: newSquare(2, rank), Piece: King, Castle: true})
+	}
+	return moves
+}
+
+// Joue un coup (supposé pseudo-légal) et retourne la nouvelle position
+func (p *Position) play(m ChessMove) *Position {
+	next := *p
+	us := p.Turn
+	piece := next.Board[m.From]
+
+	next.Board[m.From] = Piece{}
+	if m.Promotion != NoPiece {
+		piece.Type = m.Promotion
+	}
+	next.Board[m.To] = piece
+
+	if m.EnPassant {
+		captured, _ := m.To.offset(0, -pawnDirection(us))
+		next.Board[captured] = Piece{}
+	}
+
+	if m.Castle {
+		rank := m.From.Rank()
+		if m.To.File() == 6 {
+			next.Board[newSquare(5, rank)] = next.Board[newSquare(7, rank)]
+			next.Board[newSquare(7, rank)] = Piece{}
+		} else {
+			next.Board[newSquare(3, rank)] = next.Board[newSquare(0, rank)]
+			next.Board[newSquare(0, rank)] = Piece{}
+		}
+	}
+
+	// Mise à jour des droits de roque
+	if m.Piece == King {
+		if us == White {
+			next.Castling &^= castleWhiteKing | castleWhiteQueen
+		} else {
+			next.Castling &^= castleBlackKing | castleBlackQueen
+		}
+	}
+	for _, sq := range []Square{m.From, m.To} {
+		switch sq {
+		case newSquare(0, 0):
+			next.Castling &^= castleWhiteQueen
+		case newSquare(7, 0):
+			next.Castling &^= castleWhiteKing
+		case newSquare(0, 7):
+			next.Castling &^= castleBlackQueen
+		case newSquare(7, 7):
+			next.Castling &^= castleBlackKing
+		}
+	}
+
+	next.EnPassant = NoSquare
+	if m.Piece == Pawn && (m.To.Rank()-m.From.Rank() == 2 || m.From.Rank()-m.To.Rank() == 2) {
+		next.EnPassant, _ = m.From.offset(0, pawnDirection(us))
+	}
+
+	if m.Piece == Pawn || m.Captured != NoPiece {
+		next.HalfmoveClock = 0
+	} else {
+		next.HalfmoveClock++
+	}
+	if us == Black {
+		next.FullmoveNumber++
+	}
+	next.Turn = us.Opponent()
+	return &next
+}
+
+func pawnDirection(c Color) int {
+	if c == White {
+		return 1
+	}
+	return -1
+}
+
+func (p *Position) LegalMoves() []ChessMove {
+	pseudo := p.pseudoMoves()
+	legal := pseudo[:0]
+	for _, m := range pseudo {
+		next := p.play(m)
+		if !next.isAttacked(next.kingSquare(p.Turn), p.Turn.Opponent()) {
+			legal = append(legal, m)
+		}
+	}
+	return legal
+}
+
+// Joue un coup légal. La case en passant n'est conservée que si une prise
+// en passant est réellement possible, afin que la FEN identifie la position.
+func (p *Position) Apply(m ChessMove) *Position {
+	next := p.play(m)
+	if next.EnPassant != NoSquare {
+		possible := false
+		for _, reply := range next.LegalMoves() {
+			if reply.EnPassant {
+				possible = true
+				break
+			}
+		}
+		if !possible {
+			next.EnPassant = NoSquare
+		}
+	}
+	return next
+}
+
+// Retrouve le coup légal correspondant aux cases données.
+// Sans pièce de promotion précisée, la dame est choisie par défaut.
+func (p *Position) FindMove(from, to Square, promotion PieceType) (ChessMove, error) {
+	found := false
+	var match ChessMove
+	for _, m := range p.LegalMoves() {
+		if m.From != from || m.To != to {
+			continue
+		}
+		if m.Promotion == NoPiece || m.Promotion == promotion || (promotion == NoPiece && m.Promotion == Queen) {
+			match = m
+			found = true
+			break
+		}
+	}
+	if !found {
+		return ChessMove{}, fmt.Errorf("illegal move %s%s%s", from, to, promotion.Letter())
+	}
+	return match, nil
+}
+
+// Interprète un coup au format UCI (ex: "e2e4", "e7e8q")
+func (p *Position) ParseUCI(uci string) (ChessMove, error) {
+	uci = strings.ToLower(strings.TrimSpace(uci))
+	if len(uci) != 4 && len(uci) != 5 {
+		return ChessMove{}, fmt.Errorf("invalid move %q", uci)
+	}
+	from, err := ParseSquare(uci[0:2])
+	if err != nil {
+		return ChessMove{}, err
+	}
+	to, err := ParseSquare(uci[2:4])
+	if err != nil {
+		return ChessMove{}, err
+	}
+	promotion := NoPiece
+	if len(uci) == 5 {
+		promotion = parsePieceType(uci[4:])
+		if promotion == NoPiece || promotion == Pawn || promotion == King {
+			return ChessMove{}, fmt.Errorf("invalid promotion in %q", uci)
+		}
+	}
+	return p.FindMove(from, to, promotion)
+}
+
+// Aucun des deux camps ne peut mater : roi seul, roi et une pièce mineure,
+// ou uniquement des fous de même couleur de case
+func (p *Position) InsufficientMaterial() bool {
+	knights := 0
+	bishopSquareColors := map[int]bool{}
+	for sq := Square(0); sq < 64; sq++ {
+		switch p.Board[sq].Type {
+		case Pawn, Rook, Queen:
+			return false
+		case Knight:
+			knights++
+		case Bishop:
+			bishopSquareColors[(sq.File()+sq.Rank())%2] = true
+		}
+	}
+
+	bishops := len(bishopSquareColors)
+	if knights == 0 {
+		return bishops <= 1
+	}
+	return knights == 1 && bishops == 0
+}
+
+// Identifie la position pour la règle des trois répétitions
+// (placement, trait, droits de roque et prise en passant)
+func positionKey(fen string) string {
+	fields := strings.Fields(fen)
+	if len(fields) > 4 {
+		fields = fields[:4]
+	}
+	return strings.Join(fields, " ")
+}
+
+// Notation algébrique standard (SAN) du coup, calculée depuis la position
+// précédant le coup
+func (p *Position) SAN(m ChessMove) string {
+	var san string
+	switch {
+	case m.Castle && m.To.File() == 6:
+		san = "O-O"
+	case m.Castle:
+		san = "O-O-O"
+	case m.Piece == Pawn:
+		if m.Captured != NoPiece {
+			san = string(rune('a'+m.From.File())) + "x"
+		}
+		san += m.To.String()
+		if m.Promotion != NoPiece {
+			san += "=" + strings.ToUpper(m.Promotion.Letter())
+		}
+	default:
+		san = strings.ToUpper(m.Piece.Letter()) + p.disambiguation(m)
+		if m.Captured != NoPiece {
+			san += "x"
+		}
+		san += m.To.String()
+	}
+
+	next := p.Apply(m)
+	if next.InCheck() {
+		if len(next.LegalMoves()) == 0 {
+			san += "#"
+		} else {
+			san += "+"
+		}
+	}
+	return san
+}
+
+// Précise la case de départ quand plusieurs pièces identiques peuvent
+// atteindre la même case
+func (p *Position) disambiguation(m ChessMove) string {
+	ambiguous, sameFile, sameRank := false, false, false
+	for _, other := range p.LegalMoves() {
+		if other.Piece != m.Piece || other.To != m.To || other.From == m.From {
+			continue
+		}
+		ambiguous = true
+		if other.From.File() == m.From.File() {
+			sameFile = true
+		}
+		if other.From.Rank() == m.From.Rank() {
+			sameRank = true
+		}
+	}
+
+	switch {
+	case !ambiguous:
+		return ""
+	case !sameFile:
+		return m.From.String()[:1]
+	case !sameRank:
+		return m.From.String()[1:]
+	default:
+		return m.From.String()
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import "testing"
+
+func perft(p *Position, depth int) int {
+	if depth == 0 {
+		return 1
+	}
+	moves := p.LegalMoves()
+	if depth == 1 {
+		return len(moves)
+	}
+	nodes := 0
+	for _, m := range moves {
+		nodes += perft(p.Apply(m), depth-1)
+	}
+	return nodes
+}
+
+// Positions de référence de https://www.chessprogramming.org/Perft_Results
+func TestPerft(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		nodes []int
+	}{
+		{"initial", StartingFEN, []int{20, 400, 8902, 197281}},
+		{"kiwipete", "r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1", []int{48, 2039, 97862}},
+		{"position3", "8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1", []int{14, 191, 2812, 43238}},
+		{"position4", "r3k2r/Pppp1ppp/1b3nbN/nP6/BBP1P3/q4N2/Pp1P2PP/R2Q1RK1 w kq - 0 1", []int{6, 264, 9467}},
+		{"position5", "rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8", []int{44, 1486, 62379}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			position, err := ParseFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("ParseFEN: %v", err)
+			}
+			for i, want := range tt.nodes {
+				depth := i + 1
+				if testing.Short() && want > 10000 {
+					break
+				}
+				if got := perft(position, depth); got != want {
+					t.Errorf("perft(%d) = %d, want %d", depth, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestFENRoundTrip(t *testing.T) {
+	fens := []string{
+		StartingFEN,
+		"rnbqkbnr/pppp1ppp/8/4p3/4P3/8/PPPP1PPP/RNBQKBNR w KQkq e6 0 2",
+		"r3k2r/p1ppqpb1/bn2pnp1/3PN3/1p2P3/2N2Q1p/PPPBBPPP/R3K2R w KQkq - 0 1",
+		"8/2p5/3p4/KP5r/1R3p1k/8/4P1P1/8 w - - 0 1",
+		"rnbq1k1r/pp1Pbppp/2p5/8/2B5/8/PPP1NnPP/RNBQK2R w KQ - 1 8",
+		"4k3/8/8/8/8/8/8/4K2R b K - 12 40",
+	}
+
+	for _, fen := range fens {
+		position, err := ParseFEN(fen)
+		if err != nil {
+			t.Errorf("ParseFEN(%q): %v", fen, err)
+			continue
+		}
+		if got := position.FEN(); got != fen {
+			t.Errorf("FEN round trip: got %q, want %q", got, fen)
+		}
+	}
+}
+
+func TestParseFENRejectsInvalid(t *testing.T) {
+	fens := []string{
+		"",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/9/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		"rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR x KQkq - 0 1",
+	}
+
+	for _, fen := range fens {
+		if _, err := ParseFEN(fen); err == nil {
+			t.Errorf("ParseFEN(%q) succeeded, want an error", fen)
+		}
+	}
+}
+
+func TestSAN(t *testing.T) {
+	tests := []struct {
+		name  string
+		fen   string
+		moves []string
+		san   []string
+	}{
+		{
+			name:  "scholar's mate",
+			fen:   StartingFEN,
+			moves: []string{"e2e4", "e7e5", "f1c4", "b8c6", "d1h5", "g8f6", "h5f7"},
+			san:   []string{"e4", "e5", "Bc4", "Nc6", "Qh5", "Nf6", "Qxf7#"},
+		},
+		{
+			name:  "castling",
+			fen:   "r3k2r/8/8/8/8/8/8/R3K2R w KQkq - 0 1",
+			moves: []string{"e1g1", "e8c8"},
+			san:   []string{"O-O", "O-O-O"},
+		},
+		{
+			name:  "file disambiguation",
+			fen:   "6k1/8/8/8/8/8/K7/R6R w - - 0 1",
+			moves: []string{"a1d1"},
+			san:   []string{"Rad1"},
+		},
+		{
+			name:  "rank disambiguation",
+			fen:   "7k/8/R7/8/8/8/8/R6K w - - 0 1",
+			moves: []string{"a1a3"},
+			san:   []string{"R1a3"},
+		},
+		{
+			name:  "promotion with check",
+			fen:   "7k/P7/8/8/8/8/8/K7 w - - 0 1",
+			moves: []string{"a7a8q"},
+			san:   []string{"a8=Q+"},
+		},
+		{
+			name:  "en passant",
+			fen:   "4k3/8/8/3pP3/8/8/8/4K3 w - d6 0 1",
+			moves: []string{"e5d6"},
+			san:   []string{"exd6"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			position, err := ParseFEN(tt.fen)
+			if err != nil {
+				t.Fatalf("ParseFEN: %v", err)
+			}
+			for i, uci := range tt.moves {
+				move, err := position.ParseUCI(uci)
+				if err != nil {
+					t.Fatalf("ParseUCI(%q): %v", uci, err)
+				}
+				if got := position.SAN(move); got != tt.san[i] {
+					t.Errorf("SAN(%s) = %q, want %q", uci, got, tt.san[i])
+				}
+				if got := move.UCI(); got != uci {
+					t.Errorf("UCI() = %q, want %q", got, uci)
+				}
+				position = position.Apply(move)
+			}
+		})
+	}
+}
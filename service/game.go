@@ -1,10 +1,13 @@
 package service
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
 	"sync"
 	"time"
+
+	"chess_backend/protocol"
 )
 
 type ChessGameRoom struct {
@@ -12,6 +15,7 @@ type ChessGameRoom struct {
 	WhitePlayer OnlineUser `json:"white_player"`
 	BlackPlayer OnlineUser `json:"black_player"`
 	CreatedAt   time.Time  `json:"created_at"`
+	EndedAt     time.Time  `json:"ended_at,omitempty"`
 	Connections map[string]*SafeConn `json:"-"`
 	mutex       sync.RWMutex
 	GameState   map[string]interface{} `json:"game_state,omitempty"`
@@ -21,20 +25,52 @@ type ChessGameRoom struct {
 	GameCreatorUID string `json:"game_creator_uid"`
 	PositionFEN    string `json:"position_fen"`
 	WinnerID       string `json:"winner_id,omitempty"`
+	Result         string         `json:"result,omitempty"`
+	Termination    GameOverReason `json:"termination,omitempty"`
 	WhitesTime     string `json:"whites_time"`
 	BlacksTime     string `json:"blacks_time"`
 	IsWhitesTurn   bool   `json:"is_whites_turn"`
 	IsGameOver     bool   `json:"is_game_over"`
 	Moves          []Move `json:"moves"`
+	DrawOfferedBy  string `json:"draw_offered_by,omitempty"`
+	TakebackRequestedBy string `json:"takeback_requested_by,omitempty"`
 	Timer          *ChessTimer
 	InvitationTimeout *InvitationTimeout
 	onlineManager *OnlineUsersManager
+	// Joueurs déconnectés en attente de reconnexion
+	absences map[string]*playerAbsence
+	// Spectateurs connectés, qui reçoivent les coups sans pouvoir jouer
+	spectators map[string]*SafeConn
+	// Journal borné des messages des joueurs et des spectateurs
+	ChatLog      []ChatMessage `json:"chat_log"`
+	chatActivity map[string][]time.Time
+	// Partie restaurée au démarrage, pendules arrêtées jusqu'au retour des joueurs
+	restored bool
 }
 
 type Move struct {
-	From  string `json:"from"`
-	To    string `json:"to"`
-	Piece string `json:"piece"`
+	Ply         int       `json:"ply"`
+	From        string    `json:"from"`
+	To          string    `json:"to"`
+	Piece       string    `json:"piece"`
+	Promotion   string    `json:"promotion,omitempty"`
+	SAN         string    `json:"san"`
+	UCI         string    `json:"uci"`
+	FEN         string    `json:"fen"`
+	Mover       string    `json:"mover"`
+	Color       string    `json:"color"`
+	WhiteTimeMs int64     `json:"whiteTimeMs"`
+	BlackTimeMs int64     `json:"blackTimeMs"`
+	PlayedAt    time.Time `json:"playedAt"`
+}
+
+// Coup validé par le serveur, avec la fin de partie éventuelle qu'il provoque
+type PlayedMove struct {
+	Move     ChessMove
+	Record   Move
+	Position *Position
+	Outcome  GameOutcome
+	GameOver bool
 }
 
 type RoomStatus string
@@ -63,10 +99,40 @@ func NewRoomManager(onlineManager *OnlineUsersManager) *RoomManager {
 	}
 }
 
-func (rm *RoomManager) CreateRoom(invitation InvitationMessage) *ChessGameRoom {
+// L'identifiant d'une partie existante, en cours ou archivée, n'est jamais
+// réutilisé : la room en cours ou l'archive de ses joueurs serait remplacée.
+// Appelée avec rm.mutex verrouillé.
+func (rm *RoomManager) checkRoomID(roomID string) error {
+	if roomID == "" {
+		return protocol.NewError(protocol.CodeInvalidPayload, "missing room id")
+	}
+	if !validGameID(roomID) {
+		return protocol.NewError(protocol.CodeInvalidPayload, "invalid room id")
+	}
+	if _, exists := rm.rooms[roomID]; exists {
+		return protocol.Errorf(protocol.CodeRoomExists, "room %s already exists", roomID)
+	}
+	if rm.onlineManager != nil && rm.onlineManager.gameRepository != nil && rm.onlineManager.gameRepository.Exists(roomID) {
+		return protocol.Errorf(protocol.CodeRoomExists, "game %s already exists", roomID)
+	}
+	return nil
+}
+
+// Vérifie qu'une nouvelle partie peut prendre cet identifiant
+func (rm *RoomManager) CheckRoomID(roomID string) error {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+	return rm.checkRoomID(roomID)
+}
+
+func (rm *RoomManager) CreateRoom(invitation InvitationMessage) (*ChessGameRoom, error) {
 	rm.mutex.Lock()
 	defer rm.mutex.Unlock()
 
+	if err := rm.checkRoomID(invitation.RoomID); err != nil {
+		return nil, err
+	}
+
 	room := &ChessGameRoom{
 		RoomID: invitation.RoomID,
 		WhitePlayer: OnlineUser{
@@ -84,21 +150,31 @@ func (rm *RoomManager) CreateRoom(invitation InvitationMessage) *ChessGameRoom {
 
 		// Initialize new fields
 		GameCreatorUID: invitation.FromUserID,
-		PositionFEN:    "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1",
+		PositionFEN:    StartingFEN,
 		WhitesTime:     "",
 		BlacksTime:     "",
 		IsWhitesTurn:   true,
 		IsGameOver:     false,
 		Moves:          []Move{},
 		onlineManager:  rm.onlineManager,
+		absences:       make(map[string]*playerAbsence),
+		spectators:     make(map[string]*SafeConn),
+		ChatLog:        []ChatMessage{},
+		chatActivity:   make(map[string][]time.Time),
+	}
+
+	timeControl, err := normalizeTimeControl(invitation.TimeControl)
+	if err != nil {
+		timeControl = DefaultTimeControl
 	}
+	room.WhitesTime = formatTime(timeControl.BaseMinutes * 60)
+	room.BlacksTime = room.WhitesTime
 
-	timer := NewChessTimer(room, 10)
-	room.Timer = timer
-	timer.Start()
+	// Le timer est démarré une fois le message game_start envoyé aux joueurs
+	room.Timer = NewChessTimer(room, timeControl)
 
 	rm.rooms[invitation.RoomID] = room
-	return room
+	return room, nil
 }
 
 
@@ -113,16 +189,27 @@ func (rm *RoomManager) GetRoom(roomID string) (*ChessGameRoom, bool) {
 
 func (rm *RoomManager) RemoveRoom(roomID string) {
 	rm.mutex.Lock()
-	defer rm.mutex.Unlock()
-
-	
-	if room, exists := rm.rooms[roomID]; exists {
-		
-		if room.Timer != nil {
-			room.Timer.Stop()
-		}
+	room, exists := rm.rooms[roomID]
+	if exists {
 		delete(rm.rooms, roomID)
 	}
+	rm.mutex.Unlock()
+
+	if !exists {
+		return
+	}
+	if room.Timer != nil {
+		room.Timer.Stop()
+	}
+	room.mutex.Lock()
+	for username, absence := range room.absences {
+		absence.timer.Stop()
+		delete(room.absences, username)
+	}
+	room.mutex.Unlock()
+
+	// Conserver la partie dans l'archive avant de l'oublier
+	rm.archiveRoom(room)
 }
 
 func (room *ChessGameRoom) AddConnection(username string, conn *SafeConn) {
@@ -131,6 +218,144 @@ func (room *ChessGameRoom) AddConnection(username string, conn *SafeConn) {
 	room.Connections[username] = conn
 }
 
+// Valide le coup reçu du client contre la position courante, l'applique
+// et vérifie si la partie est terminée
+func (room *ChessGameRoom) PlayMove(rawMove json.RawMessage, mover string) (PlayedMove, error) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if room.IsGameOver {
+		return PlayedMove{}, protocol.NewError(protocol.CodeGameOver, "game is over")
+	}
+
+	position, err := ParseFEN(room.PositionFEN)
+	if err != nil {
+		return PlayedMove{}, err
+	}
+
+	// Un joueur ne joue que ses propres pièces, et seulement à son tour
+	color, isPlayer := room.ColorOf(mover)
+	if !isPlayer {
+		return PlayedMove{}, protocol.NewError(protocol.CodeNotInRoom, "you are not a player in this game")
+	}
+	if color != position.Turn {
+		return PlayedMove{}, protocol.NewError(protocol.CodeNotYourTurn, "it is not your turn")
+	}
+
+	move, err := parseClientMove(position, rawMove)
+	if err != nil {
+		if from, ok := clientMoveFrom(rawMove); ok {
+			if piece := position.Board[from]; piece.Type != NoPiece && piece.Color != color {
+				return PlayedMove{}, protocol.NewError(protocol.CodeWrongColor, "you can only move your own pieces")
+			}
+		}
+		return PlayedMove{}, protocol.Errorf(protocol.CodeIllegalMove, "%v", err)
+	}
+
+	next := position.Apply(move)
+	record := Move{
+		Ply:       len(room.Moves) + 1,
+		From:      move.From.String(),
+		To:        move.To.String(),
+		Piece:     move.Piece.Letter(),
+		Promotion: move.Promotion.Letter(),
+		SAN:       position.SAN(move),
+		UCI:       move.UCI(),
+		FEN:       next.FEN(),
+		Mover:     mover,
+		Color:     position.Turn.String(),
+		PlayedAt:  time.Now(),
+	}
+	room.PositionFEN = record.FEN
+	room.IsWhitesTurn = next.Turn == White
+	room.Moves = append(room.Moves, record)
+
+	// Jouer un coup vaut refus de la nulle proposée par l'adversaire et
+	// rend caduque toute demande d'annulation de coup
+	if room.DrawOfferedBy != mover {
+		room.DrawOfferedBy = ""
+	}
+	room.TakebackRequestedBy = ""
+
+	played := PlayedMove{Move: move, Record: record, Position: next}
+	played.Outcome, played.GameOver = detectGameEnd(next, room.positionHistory())
+	return played, nil
+}
+
+// Enregistre les pendules au moment du coup donné
+func (room *ChessGameRoom) SetMoveClocks(ply int, whiteTimeMs, blackTimeMs int64) {
+	room.mutex.Lock()
+	defer room.mutex.Unlock()
+
+	if ply < 1 || ply > len(room.Moves) {
+		return
+	}
+	room.Moves[ply-1].WhiteTimeMs = whiteTimeMs
+	room.Moves[ply-1].BlackTimeMs = blackTimeMs
+	room.WhitesTime = formatTime(int(whiteTimeMs / 1000))
+	room.BlacksTime = formatTime(int(blackTimeMs / 1000))
+}
+
+// FEN de toutes les positions atteintes depuis le début de la partie
+func (room *ChessGameRoom) positionHistory() []string {
+	history := make([]string, 0, len(room.Moves)+1)
+	history = append(history, StartingFEN)
+	for _, move := range room.Moves {
+		history = append(history, move.FEN)
+	}
+	return history
+}
+
+// Le coup peut être envoyé en UCI ("e2e4", "e7e8q")
+// ou sous forme d'objet {"from": "e2", "to": "e4", "promotion": "q"}
+func parseClientMove(position *Position, rawMove json.RawMessage) (ChessMove, error) {
+	var uci string
+	if err := json.Unmarshal(rawMove, &uci); err == nil {
+		return position.ParseUCI(uci)
+	}
+
+	var move struct {
+		From      string `json:"from"`
+		To        string `json:"to"`
+		Promotion string `json:"promotion"`
+	}
+	if err := json.Unmarshal(rawMove, &move); err != nil {
+		return ChessMove{}, fmt.Errorf("invalid move format")
+	}
+
+	from, err := ParseSquare(move.From)
+	if err != nil {
+		return ChessMove{}, err
+	}
+	to, err := ParseSquare(move.To)
+	if err != nil {
+		return ChessMove{}, err
+	}
+	return position.FindMove(from, to, parsePieceType(move.Promotion))
+}
+
+// Case de départ d'un coup client, qu'il soit légal ou non
+func clientMoveFrom(rawMove json.RawMessage) (Square, bool) {
+	var from string
+	var uci string
+	if err := json.Unmarshal(rawMove, &uci); err == nil {
+		if len(uci) < 2 {
+			return NoSquare, false
+		}
+		from = uci[:2]
+	} else {
+		var move struct {
+			From string `json:"from"`
+		}
+		if err := json.Unmarshal(rawMove, &move); err != nil {
+			return NoSquare, false
+		}
+		from = move.From
+	}
+	square, err := ParseSquare(from)
+	return square, err == nil
+}
+
 func (room *ChessGameRoom) GetOtherPlayer(username string) (string, bool) {
 	if room.WhitePlayer.Username == username {
 		return room.BlackPlayer.Username, true
@@ -186,13 +411,15 @@ func (m *OnlineUsersManager) RemoveUserFromRoom(username string) ([]OnlineUser,
 		return nil, fmt.Errorf("could not find other player in room")
 	}
 
-	// Remove the room
-	m.roomManager.RemoveRoom(roomToRemove.RoomID)
+	// Une partie commencée est annulée ou perdue par abandon, sinon la room est fermée
+	if !m.endGameOnLeave(roomToRemove, username) {
+		m.roomManager.RemoveRoom(roomToRemove.RoomID)
+	}
 
 	// Update user statuses
-	m.userStore.UpdateUserRoomStatus(username, false)
+	m.presence.SetInRoom(username, false)
 	if otherUsername != "" {
-		m.userStore.UpdateUserRoomStatus(otherUsername, false)
+		m.presence.SetInRoom(otherUsername, false)
 	}
 
 	// Broadcast and return online users
@@ -0,0 +1,245 @@
+package service
+
+import (
+	"log"
+	"time"
+
+	"chess_backend/protocol"
+)
+
+const (
+	ReasonResignation   GameOverReason = "resignation"
+	ReasonDrawAgreement GameOverReason = "draw_agreement"
+
+	// Une partie ne peut être annulée qu'avant le premier coup de chaque camp
+	maxAbortPlies = 2
+)
+
+// Couleur d'un joueur de la room
+func (room *ChessGameRoom) ColorOf(username string) (Color, bool) {
+	switch username {
+	case room.WhitePlayer.Username:
+		return White, true
+	case room.BlackPlayer.Username:
+		return Black, true
+	}
+	return White, false
+}
+
+// Quitter une partie commencée la termine comme game_abort tant que chaque
+// camp n'a pas joué, et la fait perdre par abandon, classement compris, au-delà.
+// Retourne false si aucun coup n'a été joué ou si la partie est déjà terminée :
+// la room est alors simplement fermée.
+func (m *OnlineUsersManager) endGameOnLeave(room *ChessGameRoom, username string) bool {
+	color, isPlayer := room.ColorOf(username)
+	if !isPlayer {
+		return false
+	}
+	room.mutex.RLock()
+	plies := len(room.Moves)
+	isGameOver := room.IsGameOver
+	room.mutex.RUnlock()
+	if isGameOver || plies == 0 {
+		return false
+	}
+	if plies < maxAbortPlies {
+		return m.endGame(room, GameOutcome{Reason: ReasonAborted})
+	}
+	return m.endGame(room, GameOutcome{Winner: color.Opponent().String(), Reason: ReasonAbandoned})
+}
+
+// Gère l'abandon, les propositions de nulle et l'annulation d'une partie
+func (m *OnlineUsersManager) handleGameAction(action string, username string, gameID string) error {
+	room, err := m.playerRoom(username, gameID)
+	if err != nil {
+		return err
+	}
+	color, _ := room.ColorOf(username)
+	opponent, _ := room.GetOtherPlayer(username)
+
+	room.mutex.Lock()
+	if room.IsGameOver {
+		room.mutex.Unlock()
+		return protocol.NewError(protocol.CodeGameOver, "game is over")
+	}
+
+	switch action {
+	case "game_resign":
+		room.mutex.Unlock()
+		m.endGame(room, GameOutcome{Winner: color.Opponent().String(), Reason: ReasonResignation})
+
+	case "draw_offer":
+		if room.DrawOfferedBy == opponent {
+			// Les deux joueurs proposent la nulle : elle est conclue
+			room.mutex.Unlock()
+			m.endGame(room, GameOutcome{Reason: ReasonDrawAgreement})
+			return nil
+		}
+		if room.DrawOfferedBy == username {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "draw already offered")
+		}
+		room.DrawOfferedBy = username
+		opponentConn, connected := room.Connections[opponent]
+		room.mutex.Unlock()
+
+		if connected {
+			opponentConn.WriteJSON(WebSocketMessage{
+				Type: "draw_offer",
+				Content: string(mustJson(map[string]string{
+					"gameId":       gameID,
+					"fromUsername": username,
+				})),
+			})
+		}
+
+	case "draw_accept":
+		if room.DrawOfferedBy != opponent {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "no draw offer to accept")
+		}
+		room.mutex.Unlock()
+		m.endGame(room, GameOutcome{Reason: ReasonDrawAgreement})
+
+	case "draw_decline":
+		if room.DrawOfferedBy != opponent {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "no draw offer to decline")
+		}
+		room.DrawOfferedBy = ""
+		opponentConn, connected := room.Connections[opponent]
+		room.mutex.Unlock()
+
+		if connected {
+			opponentConn.WriteJSON(WebSocketMessage{
+				Type: "draw_declined",
+				Content: string(mustJson(map[string]string{
+					"gameId":       gameID,
+					"fromUsername": username,
+				})),
+			})
+		}
+
+	case "game_abort":
+		if len(room.Moves) >= maxAbortPlies {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "game can only be aborted before each side has moved")
+		}
+		room.mutex.Unlock()
+		m.endGame(room, GameOutcome{Reason: ReasonAborted})
+
+	case "takeback_request":
+		if room.TakebackRequestedBy == username {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "takeback already requested")
+		}
+		if !room.hasMoved(color) {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "no move to take back")
+		}
+		room.TakebackRequestedBy = username
+		opponentConn, connected := room.Connections[opponent]
+		room.mutex.Unlock()
+
+		if connected {
+			opponentConn.WriteJSON(WebSocketMessage{
+				Type: "takeback_request",
+				Content: string(mustJson(map[string]string{
+					"gameId":       gameID,
+					"fromUsername": username,
+				})),
+			})
+		}
+
+	case "takeback_accept":
+		if room.TakebackRequestedBy != opponent {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "no takeback request to accept")
+		}
+		room.TakebackRequestedBy = ""
+		undone, clocks := room.takeBack(color.Opponent())
+		room.mutex.Unlock()
+
+		if undone == 0 {
+			return protocol.NewError(protocol.CodeActionRejected, "no move to take back")
+		}
+		room.Timer.Rewind(clocks)
+
+		snapshot := room.Snapshot()
+		snapshot["undonePlies"] = undone
+		room.BroadcastPublic(WebSocketMessage{
+			Type:    "takeback_applied",
+			Content: string(mustJson(snapshot)),
+		})
+
+	case "takeback_decline":
+		if room.TakebackRequestedBy != opponent {
+			room.mutex.Unlock()
+			return protocol.NewError(protocol.CodeActionRejected, "no takeback request to decline")
+		}
+		room.TakebackRequestedBy = ""
+		opponentConn, connected := room.Connections[opponent]
+		room.mutex.Unlock()
+
+		if connected {
+			opponentConn.WriteJSON(WebSocketMessage{
+				Type: "takeback_declined",
+				Content: string(mustJson(map[string]string{
+					"gameId":       gameID,
+					"fromUsername": username,
+				})),
+			})
+		}
+
+	default:
+		room.mutex.Unlock()
+		return protocol.Errorf(protocol.CodeUnknownType, "unknown game action %s", action)
+	}
+
+	log.Printf("Game %s: %s by %s", gameID, action, username)
+	return nil
+}
+
+// Indique si le camp donné a déjà joué un coup (appelant verrouillé)
+func (room *ChessGameRoom) hasMoved(color Color) bool {
+	for _, move := range room.Moves {
+		if move.Color == color.String() {
+			return true
+		}
+	}
+	return false
+}
+
+// Annule le dernier coup du demandeur, ainsi que la réponse de l'adversaire
+// s'il a déjà joué. Retourne le nombre de demi-coups annulés et l'état des
+// pendules à restaurer (appelant verrouillé).
+func (room *ChessGameRoom) takeBack(requester Color) (int, ClockState) {
+	undone := 0
+	for len(room.Moves) > 0 && undone < 2 {
+		last := room.Moves[len(room.Moves)-1]
+		room.Moves = room.Moves[:len(room.Moves)-1]
+		undone++
+		if last.Color == requester.String() {
+			break
+		}
+	}
+	if undone == 0 {
+		return 0, ClockState{}
+	}
+
+	clocks := ClockState{Plies: len(room.Moves), IsWhitesTurn: true}
+	room.PositionFEN = StartingFEN
+	if len(room.Moves) > 0 {
+		previous := room.Moves[len(room.Moves)-1]
+		room.PositionFEN = previous.FEN
+		clocks.WhiteTime = time.Duration(previous.WhiteTimeMs) * time.Millisecond
+		clocks.BlackTime = time.Duration(previous.BlackTimeMs) * time.Millisecond
+		clocks.IsWhitesTurn = previous.Color == Black.String()
+	} else if room.Timer != nil {
+		initial := time.Duration(room.Timer.TimeControl().BaseMinutes) * time.Minute
+		clocks.WhiteTime, clocks.BlackTime = initial, initial
+	}
+	room.IsWhitesTurn = clocks.IsWhitesTurn
+	room.DrawOfferedBy = ""
+	return undone, clocks
+}
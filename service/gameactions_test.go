@@ -0,0 +1,51 @@
+package service
+
+import "testing"
+
+func TestLeavingAGame(t *testing.T) {
+	tests := []struct {
+		name        string
+		moves       []string
+		result      string
+		termination GameOverReason
+		rated       bool
+	}{
+		{"after one ply", []string{"e2e4"}, "*", ReasonAborted, false},
+		{"after both sides moved", []string{"e2e4", "e7e5"}, "0-1", ReasonAbandoned, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			alice := UserProfile{ID: "1", UserName: "alice"}
+			bob := UserProfile{ID: "2", UserName: "bob"}
+			m := newTestManager(t, alice, bob)
+			room, err := m.roomManager.CreateRoom(InvitationMessage{
+				FromUserID:   alice.ID,
+				FromUsername: alice.UserName,
+				ToUserID:     bob.ID,
+				ToUsername:   bob.UserName,
+				RoomID:       "game",
+			})
+			if err != nil {
+				t.Fatalf("CreateRoom: %v", err)
+			}
+			room.Timer.Start()
+			playTestMoves(t, room, tt.moves...)
+
+			if !m.endGameOnLeave(room, alice.UserName) {
+				t.Fatal("leaving did not end the game")
+			}
+			room.mutex.RLock()
+			result, termination := room.Result, room.Termination
+			room.mutex.RUnlock()
+			if result != tt.result || termination != tt.termination {
+				t.Errorf("game ended %s (%s), want %s (%s)", result, termination, tt.result, tt.termination)
+			}
+
+			user, _ := m.userStore.GetUser(alice.UserName)
+			if _, rated := user.Ratings[CategoryFor(DefaultTimeControl)]; rated != tt.rated {
+				t.Errorf("rated = %v, want %v", rated, tt.rated)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package service
+
+import (
+	"log"
+	"time"
+)
+
+type GameOverReason string
+
+const (
+	ReasonCheckmate            GameOverReason = "checkmate"
+	ReasonStalemate            GameOverReason = "stalemate"
+	ReasonInsufficientMaterial GameOverReason = "insufficient_material"
+	ReasonThreefoldRepetition  GameOverReason = "threefold_repetition"
+	ReasonFiftyMoveRule        GameOverReason = "fifty_move_rule"
+	ReasonTimeout              GameOverReason = "timeout"
+	ReasonAborted              GameOverReason = "aborted"
+	ReasonAbandoned            GameOverReason = "abandoned"
+)
+
+// Résultat d'une partie terminée. Winner vaut "white", "black" ou "" pour une nulle.
+type GameOutcome struct {
+	Winner string
+	Reason GameOverReason
+}
+
+// Détermine si la position met fin à la partie. history contient les FEN de
+// toutes les positions atteintes, position courante incluse.
+func detectGameEnd(position *Position, history []string) (GameOutcome, bool) {
+	if len(position.LegalMoves()) == 0 {
+		if position.InCheck() {
+			return GameOutcome{Winner: position.Turn.Opponent().String(), Reason: ReasonCheckmate}, true
+		}
+		return GameOutcome{Reason: ReasonStalemate}, true
+	}
+
+	if position.InsufficientMaterial() {
+		return GameOutcome{Reason: ReasonInsufficientMaterial}, true
+	}
+
+	current := positionKey(position.FEN())
+	repetitions := 0
+	for _, fen := range history {
+		if positionKey(fen) == current {
+			repetitions++
+		}
+	}
+	if repetitions >= 3 {
+		return GameOutcome{Reason: ReasonThreefoldRepetition}, true
+	}
+
+	if position.HalfmoveClock >= 100 {
+		return GameOutcome{Reason: ReasonFiftyMoveRule}, true
+	}
+
+	return GameOutcome{}, false
+}
+
+// Termine la partie : enregistre le résultat, arrête le timer, notifie les
+// joueurs puis supprime la room. Retourne false si la partie était déjà terminée.
+func (m *OnlineUsersManager) endGame(room *ChessGameRoom, outcome GameOutcome) bool {
+	room.mutex.Lock()
+	if room.IsGameOver {
+		room.mutex.Unlock()
+		return false
+	}
+	room.IsGameOver = true
+	room.EndedAt = time.Now()
+	room.Status = RoomStatusFinished
+	room.Termination = outcome.Reason
+	switch outcome.Winner {
+	case "white":
+		room.WinnerID = room.WhitePlayer.ID
+		room.Result = "1-0"
+	case "black":
+		room.WinnerID = room.BlackPlayer.ID
+		room.Result = "0-1"
+	default:
+		room.WinnerID = ""
+		room.Result = "1/2-1/2"
+		if outcome.Reason == ReasonAborted || outcome.Reason == ReasonAbandoned {
+			room.Result = "*"
+		}
+	}
+	for username, absence := range room.absences {
+		absence.timer.Stop()
+		delete(room.absences, username)
+	}
+	whiteUsername := room.WhitePlayer.Username
+	blackUsername := room.BlackPlayer.Username
+	result := room.Result
+	gameOver := map[string]interface{}{
+		"gameId":     room.RoomID,
+		"winner":     outcome.Winner,
+		"reason":     string(outcome.Reason),
+		"winnerId":   room.WinnerID,
+		"result":     room.Result,
+		"fen":        room.PositionFEN,
+		"isGameOver": true,
+		"status":     string(RoomStatusFinished),
+	}
+	room.mutex.Unlock()
+
+	if room.Timer != nil {
+		room.Timer.Stop()
+		white, black := room.Timer.Remaining()
+		gameOver["whiteTime"] = formatTime(int(white / time.Second))
+		gameOver["blackTime"] = formatTime(int(black / time.Second))
+		gameOver["whiteTimeMs"] = white.Milliseconds()
+		gameOver["blackTimeMs"] = black.Milliseconds()
+	}
+
+	m.cleanupPlayerFromPublicQueue(whiteUsername)
+	m.cleanupPlayerFromPublicQueue(blackUsername)
+
+	// Mettre à jour les classements, sauf pour une partie annulée
+	if whiteScore, rated := whiteScoreFor(result); rated && room.Timer != nil {
+		category := CategoryFor(room.Timer.TimeControl())
+		whiteChange, blackChange, err := m.userStore.ApplyGameResult(room.RoomID, whiteUsername, blackUsername, category, whiteScore)
+		if err != nil {
+			log.Printf("Error updating ratings for game %s: %v", room.RoomID, err)
+		} else {
+			gameOver["ratingCategory"] = category
+			gameOver["ratingChanges"] = map[string]float64{
+				"white": whiteChange,
+				"black": blackChange,
+			}
+		}
+	}
+
+	room.BroadcastPublic(WebSocketMessage{
+		Type:    "game_over",
+		Content: string(mustJson(gameOver)),
+	})
+	log.Printf("Game %s over: %s (%s)", room.RoomID, room.Result, outcome.Reason)
+
+	//  Nettoyer la room après un délai 2 secondes
+	go func() {
+		time.Sleep(2 * time.Second)
+		m.roomManager.RemoveRoom(room.RoomID)
+		m.presence.SetInRoom(whiteUsername, false)
+		m.presence.SetInRoom(blackUsername, false)
+		m.broadcastOnlineUsers()
+	}()
+
+	return true
+}
@@ -0,0 +1,282 @@
+package service
+
+import (
+	"log"
+
+	"chess_backend/protocol"
+)
+
+// Session d'un client connecté, transmise à chaque handler
+type clientSession struct {
+	username string
+	conn     *SafeConn
+}
+
+// Handlers des messages WebSocket, indexés par type
+func (m *OnlineUsersManager) newHandlerRegistry() *protocol.Registry[*clientSession] {
+	registry := protocol.NewRegistry[*clientSession]()
+	registry.Use(m.authorize)
+
+	protocol.Handle(registry, protocol.TypeHello, func(s *clientSession, p *protocol.Hello) error {
+		return m.onHello(s, p, registry.Types())
+	})
+	protocol.Handle(registry, protocol.TypeRequestOnlineUsers, m.onRequestOnlineUsers)
+
+	protocol.Handle(registry, protocol.TypeInvitationSend, func(s *clientSession, p *protocol.InvitationSend) error {
+		return m.onInvitation(s, InvitationSend, &p.Invitation)
+	})
+	for msgType, invitationType := range map[string]InvitationMessageType{
+		protocol.TypeInvitationAccept: InvitationAccept,
+		protocol.TypeInvitationReject: InvitationReject,
+		protocol.TypeInvitationCancel: InvitationCancel,
+	} {
+		invitationType := invitationType
+		protocol.Handle(registry, msgType, func(s *clientSession, p *protocol.InvitationReply) error {
+			return m.onInvitation(s, invitationType, &p.Invitation)
+		})
+	}
+	protocol.Handle(registry, protocol.TypeRoomLeave, m.onRoomLeave)
+
+	protocol.Handle(registry, protocol.TypeGameMove, m.onGameMove)
+	protocol.Handle(registry, protocol.TypeGameOverClaim, m.onGameOverClaim)
+	for _, action := range []string{
+		protocol.TypeGameResign, protocol.TypeDrawOffer, protocol.TypeDrawAccept, protocol.TypeDrawDecline,
+		protocol.TypeGameAbort, protocol.TypeTakebackRequest, protocol.TypeTakebackAccept, protocol.TypeTakebackDecline,
+	} {
+		action := action
+		protocol.Handle(registry, action, func(s *clientSession, p *protocol.GameAction) error {
+			return m.handleGameAction(action, s.username, p.GameID)
+		})
+	}
+
+	protocol.Handle(registry, protocol.TypePublicGameRequest, m.onPublicGameRequest)
+	protocol.Handle(registry, protocol.TypePublicQueueLeave, func(s *clientSession, p *protocol.Empty) error {
+		m.handlePublicQueueLeave(s.username)
+		return nil
+	})
+
+	protocol.Handle(registry, protocol.TypeSpectateJoin, func(s *clientSession, p *protocol.GameAction) error {
+		return m.handleSpectateJoin(s.username, s.conn, p.GameID)
+	})
+	protocol.Handle(registry, protocol.TypeSpectateLeave, func(s *clientSession, p *protocol.Empty) error {
+		m.handleSpectateLeave(s.username, s.conn)
+		return nil
+	})
+	protocol.Handle(registry, protocol.TypeChatMessage, func(s *clientSession, p *protocol.ChatMessage) error {
+		return m.handleChatMessage(s.username, p.GameID, p.Text)
+	})
+
+	return registry
+}
+
+// Négocie la version du protocole pour la connexion
+func (m *OnlineUsersManager) onHello(s *clientSession, p *protocol.Hello, messageTypes []string) error {
+	version, err := protocol.Negotiate(p)
+	if err != nil {
+		return err
+	}
+	s.conn.SetVersion(version)
+
+	return s.conn.WriteJSON(WebSocketMessage{
+		Type: protocol.TypeHello,
+		Content: string(mustJson(protocol.Welcome{
+			Version:       version,
+			ServerVersion: protocol.CurrentVersion,
+			MinVersion:    protocol.MinVersion,
+			MessageTypes:  messageTypes,
+		})),
+	})
+}
+
+func (m *OnlineUsersManager) onRequestOnlineUsers(s *clientSession, p *protocol.Empty) error {
+	return s.conn.WriteJSON(WebSocketMessage{
+		Type:    "online_users",
+		Content: string(mustJson(m.getCurrentOnlineUsers())),
+	})
+}
+
+func timeControlFrom(spec *protocol.TimeControl) *TimeControl {
+	if spec == nil {
+		return nil
+	}
+	return &TimeControl{
+		BaseMinutes:      spec.BaseMinutes,
+		IncrementSeconds: spec.IncrementSeconds,
+		DelaySeconds:     spec.DelaySeconds,
+		DelayType:        DelayType(spec.DelayType),
+	}
+}
+
+func (m *OnlineUsersManager) onInvitation(s *clientSession, invitationType InvitationMessageType, p *protocol.Invitation) error {
+	invitation := InvitationMessage{
+		Type:         invitationType,
+		FromUserID:   p.FromUserID,
+		FromUsername: p.FromUsername,
+		ToUserID:     p.ToUserID,
+		ToUsername:   p.ToUsername,
+		RoomID:       p.RoomID,
+		TimeControl:  timeControlFrom(p.TimeControl),
+	}
+	m.bindInvitationIdentity(&invitation, s.username)
+
+	err := m.handleInvitation(invitation)
+	if err != nil {
+		log.Printf("Failed to process invitation: %v", err)
+	}
+	m.broadcastOnlineUsers()
+	return err
+}
+
+// Quitte une room : celle indiquée (room_leave), ou la room courante du
+// joueur pour l'ancien message leave_room
+func (m *OnlineUsersManager) onRoomLeave(s *clientSession, p *protocol.RoomLeave) error {
+	roomID := p.Room()
+	if roomID == "" {
+		m.cleanupPlayerFromPublicQueue(s.username)
+		if _, err := m.RemoveUserFromRoom(s.username); err != nil {
+			return protocol.NewError(protocol.CodeNotInRoom, err.Error())
+		}
+		m.broadcastOnlineUsers()
+		return nil
+	}
+
+	invitation := InvitationMessage{Type: RoomLeave, RoomID: roomID}
+	if opponent, found := m.roomOpponent(roomID, s.username); found {
+		invitation.ToUsername = opponent
+	}
+	m.bindInvitationIdentity(&invitation, s.username)
+
+	err := m.handleInvitation(invitation)
+	m.broadcastOnlineUsers()
+	return err
+}
+
+func (m *OnlineUsersManager) roomOpponent(roomID, username string) (string, bool) {
+	room, exists := m.roomManager.GetRoom(roomID)
+	if !exists {
+		return "", false
+	}
+	return room.GetOtherPlayer(username)
+}
+
+func (m *OnlineUsersManager) onGameMove(s *clientSession, p *protocol.GameMove) error {
+	username := s.username
+	room, err := m.playerRoom(username, p.GameID)
+	if err != nil {
+		return err
+	}
+
+	// Valider le coup et calculer la nouvelle position côté serveur.
+	// Un coup arrivé après la chute du drapeau est refusé, le timer
+	// se chargeant de terminer la partie.
+	var played PlayedMove
+	if room.Timer.FlagFallen() {
+		err = protocol.NewError(protocol.CodeGameOver, "time is up")
+	} else {
+		played, err = room.PlayMove(p.Move, username)
+	}
+	if err != nil {
+		log.Printf("Rejected move from %s in room %s: %v", username, p.GameID, err)
+		room.mutex.RLock()
+		currentFEN := room.PositionFEN
+		room.mutex.RUnlock()
+		return withDetails(err, map[string]interface{}{
+			"gameId": p.GameID,
+			"fen":    currentFEN,
+		})
+	}
+	if !played.GameOver {
+		room.Timer.SwitchTurn()
+	}
+	whiteTime, blackTime := room.Timer.Remaining()
+	room.SetMoveClocks(played.Record.Ply, whiteTime.Milliseconds(), blackTime.Milliseconds())
+
+	// Le coup transmis porte la position calculée par le serveur et
+	// l'identité des joueurs telle que connue du serveur
+	opponent, _ := room.GetOtherPlayer(username)
+	fromUserID, toUserID := room.WhitePlayer.ID, room.BlackPlayer.ID
+	if played.Record.Color == Black.String() {
+		fromUserID, toUserID = toUserID, fromUserID
+	}
+	forward := WebSocketMessage{
+		Type: protocol.TypeGameMove,
+		Content: string(mustJson(map[string]interface{}{
+			"gameId":       p.GameID,
+			"move":         p.Move,
+			"fromUserId":   fromUserID,
+			"fromUsername": username,
+			"toUserId":     toUserID,
+			"toUsername":   opponent,
+			"fen":          played.Position.FEN(),
+			"isWhitesTurn": played.Position.Turn == White,
+			"san":          played.Record.SAN,
+			"uci":          played.Record.UCI,
+		})),
+	}
+
+	// Envoyer le mouvement à l'adversaire, puis aux spectateurs
+	room.mutex.RLock()
+	otherConn, exists := room.Connections[opponent]
+	room.mutex.RUnlock()
+	if exists {
+		if err := otherConn.WriteJSON(forward); err != nil {
+			log.Printf("Error sending move to other player: %v", err)
+		}
+	} else {
+		log.Printf("Connection not found for player %s", opponent)
+	}
+	room.BroadcastToSpectators(forward)
+
+	if played.GameOver {
+		m.endGame(room, played.Outcome)
+	}
+	return nil
+}
+
+// Le résultat est déterminé par le serveur : une réclamation n'est
+// acceptée que si la position la justifie
+func (m *OnlineUsersManager) onGameOverClaim(s *clientSession, p *protocol.GameOverClaim) error {
+	room, err := m.playerRoom(s.username, p.GameID)
+	if err != nil {
+		return err
+	}
+
+	room.mutex.RLock()
+	isGameOver := room.IsGameOver
+	position, err := ParseFEN(room.PositionFEN)
+	history := room.positionHistory()
+	room.mutex.RUnlock()
+
+	if isGameOver {
+		// Déjà détecté et annoncé par le serveur
+		return nil
+	}
+	if err != nil {
+		log.Printf("Invalid position in room %s: %v", p.GameID, err)
+		return err
+	}
+
+	outcome, over := detectGameEnd(position, history)
+	if !over {
+		log.Printf("Rejected game over claim from %s in room %s", s.username, p.GameID)
+		return withDetails(protocol.NewError(protocol.CodeInvalidClaim, "claimed result does not match the position"), map[string]interface{}{
+			"gameId": p.GameID,
+			"fen":    position.FEN(),
+		})
+	}
+	m.endGame(room, outcome)
+	return nil
+}
+
+func (m *OnlineUsersManager) onPublicGameRequest(s *clientSession, p *protocol.PublicGameRequest) error {
+	user, err := m.userStore.GetUser(s.username)
+	if err != nil {
+		return err
+	}
+
+	timeControl, err := normalizeTimeControl(timeControlFrom(p.TimeControl))
+	if err != nil {
+		return protocol.Errorf(protocol.CodeInvalidPayload, "invalid time control: %v", err)
+	}
+	return m.handlePublicGameRequest(s.username, user.ID, s.conn, timeControl)
+}
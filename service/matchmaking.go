@@ -0,0 +1,242 @@
+package service
+
+import (
+	"log"
+	"math"
+	"sort"
+	"time"
+)
+
+const (
+	// Écart de classement accepté dès l'entrée dans la file
+	matchBaseRatingWindow = 100.0
+	// Élargissement de la fenêtre à chaque palier d'attente
+	matchRatingWindowGrowth = 50.0
+	matchRatingWindowStep   = 5 * time.Second
+	matchMaxRatingWindow    = 500.0
+	// Deux joueurs qui viennent de s'affronter ne sont pas réappariés aussitôt
+	rematchCooldown = 2 * time.Minute
+
+	// Fréquence des passes du matchmaker
+	matchmakingInterval = time.Second
+	// Un joueur sans adversaire est retiré de la file après ce délai
+	publicQueueTimeout = 60 * time.Second
+	// Poids de la dernière attente observée dans la moyenne glissante
+	averageWaitWeight = 0.2
+)
+
+type recentPairing struct {
+	opponent string
+	at       time.Time
+}
+
+// Fenêtre de classement acceptée après un temps d'attente donné
+func ratingWindow(waited time.Duration) float64 {
+	window := matchBaseRatingWindow + matchRatingWindowGrowth*float64(waited/matchRatingWindowStep)
+	return math.Min(window, matchMaxRatingWindow)
+}
+
+// Indique si les deux joueurs viennent de s'affronter (appelant verrouillé)
+func (q *PublicGameQueue) recentlyPaired(a, b string, now time.Time) bool {
+	if last, exists := q.recentOpponents[a]; exists && last.opponent == b && now.Sub(last.at) < rematchCooldown {
+		return true
+	}
+	if last, exists := q.recentOpponents[b]; exists && last.opponent == a && now.Sub(last.at) < rematchCooldown {
+		return true
+	}
+	return false
+}
+
+// Deux joueurs sont compatibles s'ils demandent la même cadence, ne viennent
+// pas de s'affronter et que l'écart de classement entre dans la fenêtre de
+// l'un des deux (appelant verrouillé)
+func (q *PublicGameQueue) compatible(a, b *QueuedPlayer, now time.Time) bool {
+	if a.Username == b.Username || a.TimeControl != b.TimeControl {
+		return false
+	}
+	if q.recentlyPaired(a.Username, b.Username, now) {
+		return false
+	}
+	window := math.Max(ratingWindow(now.Sub(a.JoinedAt)), ratingWindow(now.Sub(b.JoinedAt)))
+	return math.Abs(a.Rating-b.Rating) <= window
+}
+
+// Mémorise l'appariement pour éviter une revanche immédiate (appelant verrouillé)
+func (q *PublicGameQueue) recordPairing(a, b string, now time.Time) {
+	q.recentOpponents[a] = recentPairing{opponent: b, at: now}
+	q.recentOpponents[b] = recentPairing{opponent: a, at: now}
+
+	for username, pairing := range q.recentOpponents {
+		if now.Sub(pairing.at) >= rematchCooldown {
+			delete(q.recentOpponents, username)
+		}
+	}
+}
+
+// Paire candidate examinée lors d'une passe du matchmaker
+type candidatePair struct {
+	first, second *QueuedPlayer
+	gap           float64
+	waited        time.Duration
+}
+
+// Position d'un joueur dans la file, envoyée à chaque passe
+type queueStatus struct {
+	player          *QueuedPlayer
+	Position        int         `json:"position"`
+	Waiting         int         `json:"waiting"`
+	WaitedMs        int64       `json:"waitedMs"`
+	EstimatedWaitMs int64       `json:"estimatedWaitMs"`
+	TimeControl     TimeControl `json:"timeControl"`
+}
+
+// Résultat d'une passe, appliqué hors du verrou de la file
+type matchmakingRound struct {
+	pairs    [][2]*QueuedPlayer
+	expired  []*QueuedPlayer
+	statuses []queueStatus
+}
+
+// Boucle du matchmaker : apparie périodiquement les joueurs de la file publique
+func (m *OnlineUsersManager) runMatchmaker() {
+	ticker := time.NewTicker(matchmakingInterval)
+	defer ticker.Stop()
+
+	for now := range ticker.C {
+		round := m.publicQueue.nextRound(now)
+
+		for _, player := range round.expired {
+			m.presence.SetQueued(player.Username, false)
+			notifyPublicGameTimeout(player)
+		}
+		for _, pair := range round.pairs {
+			log.Printf("Public game matched: %s vs %s (%s)", pair[0].Username, pair[1].Username, pair[0].TimeControl)
+			m.startPublicGame(pair[0], pair[1])
+		}
+		for _, status := range round.statuses {
+			status.player.Connection.WriteJSON(WebSocketMessage{
+				Type:    PublicQueueStatus,
+				Content: string(mustJson(status)),
+			})
+		}
+
+		if len(round.expired) > 0 {
+			m.broadcastOnlineUsers()
+		}
+	}
+}
+
+// Une passe du matchmaker : retire les joueurs expirés, choisit les meilleures
+// paires disjointes et calcule la position des joueurs restants
+func (q *PublicGameQueue) nextRound(now time.Time) matchmakingRound {
+	q.mutex.Lock()
+	defer q.mutex.Unlock()
+
+	var round matchmakingRound
+
+	players := make([]*QueuedPlayer, 0, len(q.waitingPlayers))
+	for username, player := range q.waitingPlayers {
+		if now.Sub(player.JoinedAt) >= publicQueueTimeout {
+			delete(q.waitingPlayers, username)
+			round.expired = append(round.expired, player)
+			continue
+		}
+		players = append(players, player)
+	}
+
+	// Toutes les paires compatibles, de l'écart de classement le plus faible au
+	// plus grand, puis de l'attente cumulée la plus longue à la plus courte
+	var candidates []candidatePair
+	for i := 0; i < len(players); i++ {
+		for j := i + 1; j < len(players); j++ {
+			a, b := players[i], players[j]
+			if !q.compatible(a, b, now) {
+				continue
+			}
+			// Le joueur arrivé le premier a les blancs
+			if b.JoinedAt.Before(a.JoinedAt) {
+				a, b = b, a
+			}
+			candidates = append(candidates, candidatePair{
+				first:  a,
+				second: b,
+				gap:    math.Abs(a.Rating - b.Rating),
+				waited: now.Sub(a.JoinedAt) + now.Sub(b.JoinedAt),
+			})
+		}
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		if candidates[i].gap != candidates[j].gap {
+			return candidates[i].gap < candidates[j].gap
+		}
+		return candidates[i].waited > candidates[j].waited
+	})
+
+	paired := make(map[string]bool)
+	for _, candidate := range candidates {
+		if paired[candidate.first.Username] || paired[candidate.second.Username] {
+			continue
+		}
+		paired[candidate.first.Username] = true
+		paired[candidate.second.Username] = true
+		delete(q.waitingPlayers, candidate.first.Username)
+		delete(q.waitingPlayers, candidate.second.Username)
+		q.recordPairing(candidate.first.Username, candidate.second.Username, now)
+		q.recordWait(candidate.first.TimeControl, now.Sub(candidate.first.JoinedAt))
+		q.recordWait(candidate.second.TimeControl, now.Sub(candidate.second.JoinedAt))
+		round.pairs = append(round.pairs, [2]*QueuedPlayer{candidate.first, candidate.second})
+	}
+
+	// Position de chaque joueur restant parmi ceux qui demandent la même cadence
+	byTimeControl := make(map[TimeControl][]*QueuedPlayer)
+	for _, player := range players {
+		if !paired[player.Username] {
+			byTimeControl[player.TimeControl] = append(byTimeControl[player.TimeControl], player)
+		}
+	}
+	for timeControl, waiting := range byTimeControl {
+		sort.Slice(waiting, func(i, j int) bool {
+			return waiting[i].JoinedAt.Before(waiting[j].JoinedAt)
+		})
+		for i, player := range waiting {
+			waited := now.Sub(player.JoinedAt)
+			round.statuses = append(round.statuses, queueStatus{
+				player:          player,
+				Position:        i + 1,
+				Waiting:         len(waiting),
+				WaitedMs:        waited.Milliseconds(),
+				EstimatedWaitMs: q.estimatedWait(timeControl, waited).Milliseconds(),
+				TimeControl:     timeControl,
+			})
+		}
+	}
+
+	return round
+}
+
+// Met à jour l'attente moyenne d'une cadence (appelant verrouillé)
+func (q *PublicGameQueue) recordWait(timeControl TimeControl, waited time.Duration) {
+	average, exists := q.averageWait[timeControl]
+	if !exists {
+		q.averageWait[timeControl] = waited
+		return
+	}
+	q.averageWait[timeControl] = time.Duration(float64(average)*(1-averageWaitWeight) + float64(waited)*averageWaitWeight)
+}
+
+// Attente restante estimée d'après l'attente moyenne de la cadence, bornée par
+// l'expiration de la file (appelant verrouillé)
+func (q *PublicGameQueue) estimatedWait(timeControl TimeControl, waited time.Duration) time.Duration {
+	average, exists := q.averageWait[timeControl]
+	if !exists {
+		average = publicQueueTimeout / 2
+	}
+	remaining := average - waited
+	if remaining < 0 {
+		remaining = 0
+	}
+	if limit := publicQueueTimeout - waited; remaining > limit {
+		remaining = limit
+	}
+	return remaining
+}
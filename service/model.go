@@ -2,21 +2,31 @@ package service
 
 import (
 	"sync"
+	"sync/atomic"
 	"time"
 
+	"chess_backend/protocol"
+
 	"github.com/gorilla/websocket"
 )
 
 type UserProfile struct {
 	ID       string `json:"id"`
 	UserName string `json:"username"`
-	IsOnline bool   `json:"isnOline"`
-	IsInRoom bool   `json:"isInRoom"`
+
+	// Compte invité (partie rapide, sans mot de passe) ou compte enregistré
+	AccountType       AccountType `json:"accountType,omitempty"`
+	PasswordHash      string      `json:"passwordHash,omitempty"`
+	PasswordChangedAt time.Time   `json:"passwordChangedAt,omitempty"`
+
+	Ratings       map[RatingCategory]Rating `json:"ratings,omitempty"`
+	RatingHistory []RatingHistoryEntry      `json:"ratingHistory,omitempty"`
 }
 
 type UserStore struct {
-	Users map[string]UserProfile `json:"users"`
-	mutex sync.RWMutex
+	Users      map[string]UserProfile `json:"users"`
+	repository UserRepository
+	mutex      sync.RWMutex
 }
 
 type OnlineStatusUpdate struct {
@@ -26,7 +36,9 @@ type OnlineStatusUpdate struct {
 
 // Structure pour représenter un message WebSocket
 type WebSocketMessage struct {
-	Type    string `json:"type"`
+	Type string `json:"type"`
+	// Identifiant optionnel choisi par le client, renvoyé dans l'ack ou l'erreur
+	ID      string `json:"id,omitempty"`
 	Content string `json:"content"`
 }
 
@@ -38,31 +50,54 @@ type OnlineUsersManager struct {
 	roomManager *RoomManager
 	tempRoomManager    *TemporaryRoomManager
 	publicQueue *PublicGameQueue
+	gameRepository GameRepository
+	tokenAuthority *TokenAuthority
+	handlers       *protocol.Registry[*clientSession]
+	presence       *PresenceService
 }
 
 type PublicGameQueue struct {
 	waitingPlayers map[string]*QueuedPlayer
-	mutex          sync.RWMutex
+	// Dernier adversaire de chaque joueur apparié
+	recentOpponents map[string]recentPairing
+	// Attente moyenne observée avant appariement, par cadence
+	averageWait map[TimeControl]time.Duration
+	mutex       sync.RWMutex
 }
 
 type QueuedPlayer struct {
-	UserID     string
-	Username   string
-	JoinedAt   time.Time
-	Timer      *time.Timer
-	Connection *SafeConn
+	UserID      string
+	Username    string
+	JoinedAt    time.Time
+	Connection  *SafeConn
+	TimeControl TimeControl
+	Rating      float64
 }
 
 type SafeConn struct {
 	conn  *websocket.Conn
 	mutex sync.Mutex
+	// Version du protocole négociée par le hello (v1 par défaut)
+	version int32
 }
 
 func NewSafeConn(conn *websocket.Conn) *SafeConn {
-	return &SafeConn{conn: conn}
+	return &SafeConn{conn: conn, version: protocol.Version1}
+}
+
+func (sc *SafeConn) Version() int {
+	return int(atomic.LoadInt32(&sc.version))
+}
+
+func (sc *SafeConn) SetVersion(version int) {
+	atomic.StoreInt32(&sc.version, int32(version))
 }
 
+// Les messages sont encodés selon la version négociée par le client
 func (sc *SafeConn) WriteJSON(v interface{}) error {
+	if message, ok := v.(WebSocketMessage); ok {
+		v = protocol.Encode(sc.Version(), message.Type, message.ID, message.Content)
+	}
 	sc.mutex.Lock()
 	defer sc.mutex.Unlock()
 	return sc.conn.WriteJSON(v)
@@ -91,4 +126,5 @@ type InvitationMessage struct {
 	ToUserID     string                `json:"to_user_id"`
 	ToUsername   string                `json:"to_username"`
 	RoomID       string                `json:"room_id,omitempty"`
+	TimeControl  *TimeControl          `json:"time_control,omitempty"`
 }
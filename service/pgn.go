@@ -0,0 +1,111 @@
+package service
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/mux"
+)
+
+const pgnLineWidth = 80
+
+// Valeur du tag Termination selon la raison de fin de partie
+func pgnTermination(reason GameOverReason) string {
+	if reason == "" {
+		return "unterminated"
+	}
+	switch reason {
+	case ReasonTimeout:
+		return "time forfeit"
+	case ReasonAbandoned, ReasonAborted:
+		return "abandoned"
+	default:
+		return "normal"
+	}
+}
+
+func pgnEscape(value string) string {
+	value = strings.ReplaceAll(value, `\`, `\\`)
+	return strings.ReplaceAll(value, `"`, `\"`)
+}
+
+// Formate un temps en millisecondes au format H:MM:SS des commentaires %clk
+func formatClock(ms int64) string {
+	if ms < 0 {
+		ms = 0
+	}
+	seconds := ms / 1000
+	return fmt.Sprintf("%d:%02d:%02d", seconds/3600, seconds/60%60, seconds%60)
+}
+
+// Exporte la partie au format PGN
+func (g *GameRecord) PGN() string {
+	var sb strings.Builder
+	tags := [][2]string{
+		{"Event", "Casual game"},
+		{"Site", "?"},
+		{"Date", g.CreatedAt.Format("2006.01.02")},
+		{"Round", "-"},
+		{"White", g.WhitePlayer.Username},
+		{"Black", g.BlackPlayer.Username},
+		{"Result", g.Result},
+		{"TimeControl", g.TimeControl},
+		{"Termination", pgnTermination(g.Termination)},
+	}
+	for _, tag := range tags {
+		fmt.Fprintf(&sb, "[%s \"%s\"]\n", tag[0], pgnEscape(tag[1]))
+	}
+	sb.WriteString("\n")
+
+	// Les tokens sont regroupés en lignes de 80 caractères maximum
+	tokens := make([]string, 0, len(g.Moves)*3+1)
+	for i, move := range g.Moves {
+		moveNumber := i/2 + 1
+		if i%2 == 0 {
+			tokens = append(tokens, fmt.Sprintf("%d.", moveNumber))
+		} else {
+			// Après un commentaire, le coup noir est précédé de son numéro
+			tokens = append(tokens, fmt.Sprintf("%d...", moveNumber))
+		}
+		clock := move.WhiteTimeMs
+		if move.Color == Black.String() {
+			clock = move.BlackTimeMs
+		}
+		tokens = append(tokens, move.SAN, fmt.Sprintf("{[%%clk %s]}", formatClock(clock)))
+	}
+	tokens = append(tokens, g.Result)
+
+	lineLength := 0
+	for _, token := range tokens {
+		if lineLength > 0 && lineLength+1+len(token) > pgnLineWidth {
+			sb.WriteString("\n")
+			lineLength = 0
+		}
+		if lineLength > 0 {
+			sb.WriteString(" ")
+			lineLength++
+		}
+		sb.WriteString(token)
+		lineLength += len(token)
+	}
+	sb.WriteString("\n")
+
+	return sb.String()
+}
+
+func GamePGNHandler(onlineUsersManager *OnlineUsersManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gameID := mux.Vars(r)["id"]
+
+		game, err := onlineUsersManager.findGame(gameID)
+		if err != nil {
+			http.Error(w, "Game not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/x-chess-pgn")
+		w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%q", gameID+".pgn"))
+		w.Write([]byte(game.PGN()))
+	}
+}
@@ -0,0 +1,129 @@
+package service
+
+import (
+	"sync"
+	"time"
+)
+
+type PresenceStatus string
+
+const (
+	PresenceOffline PresenceStatus = "offline"
+	// Connecté et disponible pour une invitation
+	PresenceIdle PresenceStatus = "idle"
+	// Dans une partie, y compris pendant le délai de reconnexion
+	PresencePlaying PresenceStatus = "playing"
+	// En attente dans la file publique
+	PresenceQueued PresenceStatus = "queued"
+)
+
+// Présence d'un utilisateur à un instant donné
+type Presence struct {
+	Username string         `json:"username"`
+	Status   PresenceStatus `json:"status"`
+	Online   bool           `json:"online"`
+	InRoom   bool           `json:"inRoom"`
+	LastSeen time.Time      `json:"lastSeen"`
+}
+
+type presenceEntry struct {
+	online   bool
+	inRoom   bool
+	queued   bool
+	lastSeen time.Time
+}
+
+func (entry *presenceEntry) status() PresenceStatus {
+	switch {
+	case entry.inRoom:
+		return PresencePlaying
+	case !entry.online:
+		return PresenceOffline
+	case entry.queued:
+		return PresenceQueued
+	}
+	return PresenceIdle
+}
+
+// Présence des utilisateurs, tenue uniquement en mémoire : après un
+// redémarrage, tout le monde est hors ligne jusqu'à sa reconnexion
+type PresenceService struct {
+	entries map[string]*presenceEntry
+	mutex   sync.RWMutex
+}
+
+func NewPresenceService() *PresenceService {
+	return &PresenceService{entries: make(map[string]*presenceEntry)}
+}
+
+// Applique une modification à l'entrée d'un utilisateur, créée au besoin
+func (ps *PresenceService) update(username string, apply func(entry *presenceEntry)) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	entry, exists := ps.entries[username]
+	if !exists {
+		entry = &presenceEntry{}
+		ps.entries[username] = entry
+	}
+	apply(entry)
+	entry.lastSeen = time.Now()
+}
+
+func (ps *PresenceService) Connect(username string) {
+	ps.update(username, func(entry *presenceEntry) {
+		entry.online = true
+	})
+}
+
+// La déconnexion garde la partie en cours, qui attend le retour du joueur
+func (ps *PresenceService) Disconnect(username string) {
+	ps.update(username, func(entry *presenceEntry) {
+		entry.online = false
+	})
+}
+
+func (ps *PresenceService) SetInRoom(username string, inRoom bool) {
+	ps.update(username, func(entry *presenceEntry) {
+		entry.inRoom = inRoom
+		if inRoom {
+			entry.queued = false
+		}
+	})
+}
+
+func (ps *PresenceService) SetQueued(username string, queued bool) {
+	ps.update(username, func(entry *presenceEntry) {
+		entry.queued = queued
+	})
+}
+
+// Activité du client (message reçu)
+func (ps *PresenceService) Touch(username string) {
+	ps.update(username, func(entry *presenceEntry) {})
+}
+
+// Oublie un utilisateur supprimé
+func (ps *PresenceService) Forget(username string) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+
+	delete(ps.entries, username)
+}
+
+func (ps *PresenceService) Get(username string) Presence {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	entry, exists := ps.entries[username]
+	if !exists {
+		return Presence{Username: username, Status: PresenceOffline}
+	}
+	return Presence{
+		Username: username,
+		Status:   entry.status(),
+		Online:   entry.online,
+		InRoom:   entry.inRoom,
+		LastSeen: entry.lastSeen,
+	}
+}
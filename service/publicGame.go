@@ -3,6 +3,8 @@ package service
 import (
 	"log"
 	"time"
+
+	"chess_backend/protocol"
 )
 
 const (
@@ -10,132 +12,130 @@ const (
 	PublicGameTimeout string = "public_game_timeout"
 	PublicGameMatched string = "game_start"
 	PublicQueueLeave  string = "public_queue_leave"
+	PublicQueueStatus string = "public_queue_status"
 )
 
-func (m *OnlineUsersManager) handlePublicGameRequest(username string, userID string, conn *SafeConn) {
+func (m *OnlineUsersManager) handlePublicGameRequest(username string, userID string, conn *SafeConn, timeControl TimeControl) error {
+	user, err := m.userStore.GetUser(username)
+	if err != nil {
+		return err
+	}
+
 	// Vérifier si le joueur est déjà dans une partie
-	if user, err := m.userStore.GetUser(username); err == nil && user.IsInRoom {
-		conn.WriteJSON(WebSocketMessage{
-			Type: "error",
-			Content: string(mustJson(map[string]string{
-				"message": "Vous êtes déjà dans une partie",
-			})),
-		})
-		return
+	if m.presence.Get(username).InRoom {
+		return protocol.NewError(protocol.CodeAlreadyInGame, "Vous êtes déjà dans une partie")
 	}
 
 	m.publicQueue.mutex.Lock()
 
-	// Vérifier si le joueur est déjà dans la file d'attente
-	if _, exists := m.publicQueue.waitingPlayers[username]; exists {
+	// Déjà dans la file d'attente : la place est gardée, mais la partie sera
+	// annoncée sur la connexion qui vient de renouveler la demande. L'entrée
+	// est remplacée plutôt que modifiée, le matchmaker la lisant hors du verrou.
+	if queued, exists := m.publicQueue.waitingPlayers[username]; exists {
+		if queued.Connection != conn {
+			refreshed := *queued
+			refreshed.Connection = conn
+			m.publicQueue.waitingPlayers[username] = &refreshed
+		}
 		m.publicQueue.mutex.Unlock()
-		return
+		return nil
 	}
 
-	// Chercher l'adversaire qui attend depuis le plus longtemps
-	var opponent *QueuedPlayer
-	var longestWait time.Duration
-	for _, player := range m.publicQueue.waitingPlayers {
-		waitTime := time.Since(player.JoinedAt)
-		if opponent == nil || waitTime > longestWait {
-			opponent = player
-			longestWait = waitTime
-		}
+	// Ajouter le joueur à la file d'attente ; l'appariement est fait par le matchmaker
+	m.publicQueue.waitingPlayers[username] = &QueuedPlayer{
+		UserID:      userID,
+		Username:    username,
+		JoinedAt:    time.Now(),
+		Connection:  conn,
+		TimeControl: timeControl,
+		Rating:      user.RatingFor(CategoryFor(timeControl)).Rating,
 	}
+	m.publicQueue.mutex.Unlock()
+	m.presence.SetQueued(username, true)
 
-	if opponent == nil {
-		// Aucun adversaire disponible, ajouter le joueur à la file d'attente
-		timer := time.NewTimer(60 * time.Second)
-		queuedPlayer := &QueuedPlayer{
-			UserID:     userID,
-			Username:   username,
-			JoinedAt:   time.Now(),
-			Connection: conn,
-			Timer:      timer,
-		}
+	m.broadcastOnlineUsers()
+	return nil
+}
 
-		m.publicQueue.waitingPlayers[username] = queuedPlayer
-		m.publicQueue.mutex.Unlock()
+// Créer la partie entre deux joueurs appariés par le matchmaker.
+// Le premier joueur a les blancs et est considéré comme le créateur.
+func (m *OnlineUsersManager) startPublicGame(creator *QueuedPlayer, joiner *QueuedPlayer) {
+	timeControl := creator.TimeControl
+
+	// Créer une invitation pour la partie
+	invitation := InvitationMessage{
+		Type:         InvitationAccept,
+		FromUserID:   creator.UserID,
+		FromUsername: creator.Username,
+		ToUserID:     joiner.UserID,
+		ToUsername:   joiner.Username,
+		RoomID:       GenerateUniqueID(),
+		TimeControl:  &timeControl,
+	}
 
-		m.broadcastOnlineUsers()
+	// Créer la room et démarrer la partie ; tirer un autre identifiant s'il est déjà pris
+	room, err := m.roomManager.CreateRoom(invitation)
+	for err != nil {
+		invitation.RoomID = GenerateUniqueID()
+		room, err = m.roomManager.CreateRoom(invitation)
+	}
 
-		// Gérer le timeout
-		go func() {
-			<-timer.C
-			m.handlePublicGameTimeout(username)
-		}()
+	// Mettre à jour le statut des joueurs
+	m.presence.SetInRoom(creator.Username, true)
+	m.presence.SetInRoom(joiner.Username, true)
+
+	// Préparation des états de jeu spécifiques pour chaque joueur
+	baseGameState := map[string]interface{}{
+		"gameId":         room.RoomID,
+		"gameCreatorUid": creator.UserID, // Premier joueur = créateur
+		"positonFen":     room.PositionFEN,
+		"whitesTime":     room.WhitesTime,
+		"blacksTime":     room.BlacksTime,
+		"isWhitesTurn":   true,
+		"isGameOver":     false,
+		"moves":          room.Moves,
+		"winnerId":       "",
+		"timeControl":    timeControl,
+	}
 
-	} else {
-		// Adversaire trouvé, créer la partie
-		delete(m.publicQueue.waitingPlayers, opponent.Username)
-		opponent.Timer.Stop()
-		m.publicQueue.mutex.Unlock()
+	// État pour le premier joueur (créateur)
+	player1GameState := copyAndAddUserInfo(baseGameState, creator.UserID, joiner.Username)
+
+	// État pour le second joueur
+	player2GameState := copyAndAddUserInfo(baseGameState, joiner.UserID, creator.Username)
 
-		// Créer une invitation pour la partie
-		invitation := InvitationMessage{
-			Type:         InvitationAccept,
-			FromUserID:   opponent.UserID,
-			FromUsername: opponent.Username,
-			ToUserID:     userID,
-			ToUsername:   username,
-			RoomID:       GenerateUniqueID(),
+	room.AddConnection(creator.Username, creator.Connection)
+	room.AddConnection(joiner.Username, joiner.Connection)
+
+	// Créer un timer pour le délai de 2 secondes
+	go func() {
+		// Attendre 2 secondes
+		time.Sleep(2 * time.Second)
+
+		// Les pendules ne démarrent qu'une fois le plateau envoyé ; même en
+		// cas d'échec d'envoi, le délai du premier coup finira par annuler la partie
+		defer room.Timer.Start()
+
+		// Envoyer le message de début de partie aux deux joueurs
+		if err := creator.Connection.WriteJSON(WebSocketMessage{
+			Type:    PublicGameMatched,
+			Content: string(mustJson(player1GameState)),
+		}); err != nil {
+			log.Printf("Error sending game start message to creator: %v", err)
+			return
 		}
 
-		// Créer la room et démarrer la partie
-		room := m.roomManager.CreateRoom(invitation)
-
-		// Mettre à jour le statut des joueurs
-		m.userStore.UpdateUserRoomStatus(opponent.Username, true)
-		m.userStore.UpdateUserRoomStatus(username, true)
-
-		// Préparation des états de jeu spécifiques pour chaque joueur
-		baseGameState := map[string]interface{}{
-			"gameId":         room.RoomID,
-			"gameCreatorUid": opponent.UserID, // Premier joueur = créateur
-			"positonFen":     room.PositionFEN,
-			"whitesTime":     room.WhitesTime,
-			"blacksTime":     room.BlacksTime,
-			"isWhitesTurn":   true,
-			"isGameOver":     false,
-			"moves":          []Move{},
-			"winnerId":       "",
+		if err := joiner.Connection.WriteJSON(WebSocketMessage{
+			Type:    PublicGameMatched,
+			Content: string(mustJson(player2GameState)),
+		}); err != nil {
+			log.Printf("Error sending game start message to joiner: %v", err)
+			return
 		}
 
-		// État pour le premier joueur (opponent - créateur)
-		player1GameState := copyAndAddUserInfo(baseGameState, opponent.UserID, username)
-
-		// État pour le second joueur
-		player2GameState := copyAndAddUserInfo(baseGameState, userID, opponent.Username)
-
-		room.AddConnection(opponent.Username, opponent.Connection)
-		room.AddConnection(username, conn)
-
-		// Créer un timer pour le délai de 2 secondes
-		go func() {
-			// Attendre 2 secondes
-			time.Sleep(2 * time.Second)
-
-			// Envoyer le message de début de partie aux deux joueurs
-			if err := opponent.Connection.WriteJSON(WebSocketMessage{
-				Type:    PublicGameMatched,
-				Content: string(mustJson(player1GameState)),
-			}); err != nil {
-				log.Printf("Error sending game start message to creator: %v", err)
-				return
-			}
-
-			if err := conn.WriteJSON(WebSocketMessage{
-				Type:    PublicGameMatched,
-				Content: string(mustJson(player2GameState)),
-			}); err != nil {
-				log.Printf("Error sending game start message to joiner: %v", err)
-				return
-			}
-
-			// Mettre à jour la liste des utilisateurs en ligne
-			m.broadcastOnlineUsers()
-		}()
-	}
+		// Mettre à jour la liste des utilisateurs en ligne
+		m.broadcastOnlineUsers()
+	}()
 }
 
 // Fonction pour gérer le départ de la file d'attente
@@ -144,17 +144,14 @@ func (m *OnlineUsersManager) handlePublicQueueLeave(username string) {
 	// Vérifier si le joueur est dans la file d'attente
 	player, exists := m.publicQueue.waitingPlayers[username]
 	if !exists {
+		m.publicQueue.mutex.Unlock()
 		return
 	}
 
-	// Arrêter le timer
-	if player.Timer != nil {
-		player.Timer.Stop()
-	}
-
 	// Supprimer le joueur de la file d'attente
 	delete(m.publicQueue.waitingPlayers, username)
 	m.publicQueue.mutex.Unlock()
+	m.presence.SetQueued(username, false)
 
 	// Mettre à jour la liste des utilisateurs en ligne
 	m.broadcastOnlineUsers()
@@ -170,23 +167,8 @@ func (m *OnlineUsersManager) handlePublicQueueLeave(username string) {
 	}
 }
 
-// Gérer le timeout d'une requête de partie publique
-func (m *OnlineUsersManager) handlePublicGameTimeout(username string) {
-	m.publicQueue.mutex.Lock()
-
-	player, exists := m.publicQueue.waitingPlayers[username]
-	if !exists {
-		return
-	}
-
-	// Supprimer le joueur de la file d'attente
-	delete(m.publicQueue.waitingPlayers, username)
-
-	m.publicQueue.mutex.Unlock()
-	// Broadcast la mise à jour des utilisateurs en ligne
-	m.broadcastOnlineUsers()
-
-	// Notifier le joueur du timeout
+// Notifier un joueur retiré de la file faute d'adversaire
+func notifyPublicGameTimeout(player *QueuedPlayer) {
 	player.Connection.WriteJSON(WebSocketMessage{
 		Type: PublicGameTimeout,
 		Content: string(mustJson(map[string]string{
@@ -195,14 +177,25 @@ func (m *OnlineUsersManager) handlePublicGameTimeout(username string) {
 	})
 }
 
-func (m *OnlineUsersManager) cleanupPlayerFromPublicQueue(username string) {
+// Retire le joueur de la file s'il y attend sur cette connexion : une demande
+// renouvelée depuis une nouvelle connexion garde sa place
+func (m *OnlineUsersManager) removeQueuedConnection(username string, conn *SafeConn) {
 	m.publicQueue.mutex.Lock()
-	defer m.publicQueue.mutex.Unlock()
-
-	if player, exists := m.publicQueue.waitingPlayers[username]; exists {
-		if player.Timer != nil {
-			player.Timer.Stop()
-		}
+	queued, exists := m.publicQueue.waitingPlayers[username]
+	if exists && queued.Connection == conn {
 		delete(m.publicQueue.waitingPlayers, username)
 	}
+	m.publicQueue.mutex.Unlock()
+
+	if exists && queued.Connection == conn {
+		m.presence.SetQueued(username, false)
+	}
+}
+
+func (m *OnlineUsersManager) cleanupPlayerFromPublicQueue(username string) {
+	m.publicQueue.mutex.Lock()
+	delete(m.publicQueue.waitingPlayers, username)
+	m.publicQueue.mutex.Unlock()
+
+	m.presence.SetQueued(username, false)
 }
@@ -0,0 +1,36 @@
+package service
+
+import "testing"
+
+func TestPublicQueueFollowsLatestConnection(t *testing.T) {
+	alice := UserProfile{ID: "1", UserName: "alice"}
+	m := newTestManager(t, alice)
+	first, second := NewSafeConn(nil), NewSafeConn(nil)
+	m.presence.Connect(alice.UserName)
+
+	if err := m.handlePublicGameRequest(alice.UserName, alice.ID, first, DefaultTimeControl); err != nil {
+		t.Fatalf("first request: %v", err)
+	}
+	if err := m.handlePublicGameRequest(alice.UserName, alice.ID, second, DefaultTimeControl); err != nil {
+		t.Fatalf("second request: %v", err)
+	}
+	m.publicQueue.mutex.Lock()
+	queued := m.publicQueue.waitingPlayers[alice.UserName]
+	m.publicQueue.mutex.Unlock()
+	if queued == nil || queued.Connection != second {
+		t.Fatalf("queued connection was not refreshed")
+	}
+
+	// La fermeture de l'ancienne connexion ne retire pas la demande renouvelée
+	m.removeQueuedConnection(alice.UserName, first)
+	if m.presence.Get(alice.UserName).Status != PresenceQueued {
+		t.Errorf("closing the old connection left the queue")
+	}
+	m.removeQueuedConnection(alice.UserName, second)
+	m.publicQueue.mutex.Lock()
+	_, stillQueued := m.publicQueue.waitingPlayers[alice.UserName]
+	m.publicQueue.mutex.Unlock()
+	if stillQueued {
+		t.Errorf("closing the queued connection kept the player in the queue")
+	}
+}
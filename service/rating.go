@@ -0,0 +1,241 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type RatingCategory string
+
+const (
+	CategoryBullet    RatingCategory = "bullet"
+	CategoryBlitz     RatingCategory = "blitz"
+	CategoryRapid     RatingCategory = "rapid"
+	CategoryClassical RatingCategory = "classical"
+)
+
+var ratingCategories = []RatingCategory{CategoryBullet, CategoryBlitz, CategoryRapid, CategoryClassical}
+
+// Paramètres Glicko-2
+const (
+	initialRating     = 1500.0
+	initialDeviation  = 350.0
+	initialVolatility = 0.06
+	minDeviation      = 30.0
+	glickoTau         = 0.5
+	glickoScale       = 173.7178
+	glickoEpsilon     = 0.000001
+
+	maxRatingHistory = 200
+)
+
+type Rating struct {
+	Rating     float64   `json:"rating"`
+	Deviation  float64   `json:"deviation"`
+	Volatility float64   `json:"volatility"`
+	Games      int       `json:"games"`
+	UpdatedAt  time.Time `json:"updatedAt,omitempty"`
+}
+
+type RatingHistoryEntry struct {
+	Category  RatingCategory `json:"category"`
+	GameID    string         `json:"gameId"`
+	Rating    float64        `json:"rating"`
+	Deviation float64        `json:"deviation"`
+	Change    float64        `json:"change"`
+	Date      time.Time      `json:"date"`
+}
+
+func NewRating() Rating {
+	return Rating{
+		Rating:     initialRating,
+		Deviation:  initialDeviation,
+		Volatility: initialVolatility,
+	}
+}
+
+// Catégorie de classement selon la durée estimée d'une partie de 40 coups
+func CategoryFor(tc TimeControl) RatingCategory {
+	estimated := tc.BaseMinutes*60 + 40*(tc.IncrementSeconds+tc.DelaySeconds)
+	switch {
+	case estimated < 180:
+		return CategoryBullet
+	case estimated < 480:
+		return CategoryBlitz
+	case estimated < 1500:
+		return CategoryRapid
+	default:
+		return CategoryClassical
+	}
+}
+
+// Classement d'un joueur dans une catégorie, ou le classement initial
+func (user *UserProfile) RatingFor(category RatingCategory) Rating {
+	if rating, exists := user.Ratings[category]; exists {
+		return rating
+	}
+	return NewRating()
+}
+
+func glickoG(phi float64) float64 {
+	return 1 / math.Sqrt(1+3*phi*phi/(math.Pi*math.Pi))
+}
+
+// Met à jour un classement Glicko-2 après une partie contre un adversaire.
+// score vaut 1 pour une victoire, 0.5 pour une nulle et 0 pour une défaite.
+func updateGlicko2(player, opponent Rating, score float64) Rating {
+	mu := (player.Rating - initialRating) / glickoScale
+	phi := player.Deviation / glickoScale
+	sigma := player.Volatility
+	muJ := (opponent.Rating - initialRating) / glickoScale
+	phiJ := opponent.Deviation / glickoScale
+
+	g := glickoG(phiJ)
+	expected := 1 / (1 + math.Exp(-g*(mu-muJ)))
+	v := 1 / (g * g * expected * (1 - expected))
+	delta := v * g * (score - expected)
+
+	// Nouvelle volatilité (algorithme d'Illinois)
+	a := math.Log(sigma * sigma)
+	f := func(x float64) float64 {
+		ex := math.Exp(x)
+		num := ex * (delta*delta - phi*phi - v - ex)
+		den := 2 * math.Pow(phi*phi+v+ex, 2)
+		return num/den - (x-a)/(glickoTau*glickoTau)
+	}
+	A := a
+	var B float64
+	if delta*delta > phi*phi+v {
+		B = math.Log(delta*delta - phi*phi - v)
+	} else {
+		k := 1.0
+		for f(a-k*glickoTau) < 0 {
+			k++
+		}
+		B = a - k*glickoTau
+	}
+	fA, fB := f(A), f(B)
+	for math.Abs(B-A) > glickoEpsilon {
+		C := A + (A-B)*fA/(fB-fA)
+		fC := f(C)
+		if fC*fB < 0 {
+			A, fA = B, fB
+		} else {
+			fA /= 2
+		}
+		B, fB = C, fC
+	}
+	newSigma := math.Exp(A / 2)
+
+	phiStar := math.Sqrt(phi*phi + newSigma*newSigma)
+	newPhi := 1 / math.Sqrt(1/(phiStar*phiStar)+1/v)
+	newMu := mu + newPhi*newPhi*g*(score-expected)
+
+	updated := Rating{
+		Rating:     newMu*glickoScale + initialRating,
+		Deviation:  math.Max(minDeviation, math.Min(initialDeviation, newPhi*glickoScale)),
+		Volatility: newSigma,
+		Games:      player.Games + 1,
+		UpdatedAt:  time.Now(),
+	}
+	return updated
+}
+
+// Met à jour le classement des deux joueurs après une partie.
+// whiteScore vaut 1, 0.5 ou 0 du point de vue des blancs.
+// Retourne la variation de classement de chaque joueur.
+func (us *UserStore) ApplyGameResult(gameID, whiteUsername, blackUsername string, category RatingCategory, whiteScore float64) (float64, float64, error) {
+	us.mutex.Lock()
+	defer us.mutex.Unlock()
+
+	white, whiteExists := us.Users[whiteUsername]
+	black, blackExists := us.Users[blackUsername]
+	if !whiteExists || !blackExists {
+		return 0, 0, fmt.Errorf("user not found")
+	}
+
+	whiteBefore := white.RatingFor(category)
+	blackBefore := black.RatingFor(category)
+	whiteAfter := updateGlicko2(whiteBefore, blackBefore, whiteScore)
+	blackAfter := updateGlicko2(blackBefore, whiteBefore, 1-whiteScore)
+
+	whiteChange := whiteAfter.Rating - whiteBefore.Rating
+	blackChange := blackAfter.Rating - blackBefore.Rating
+	white.recordRating(category, gameID, whiteAfter, whiteChange)
+	black.recordRating(category, gameID, blackAfter, blackChange)
+	us.Users[whiteUsername] = white
+	us.Users[blackUsername] = black
+
+	return whiteChange, blackChange, us.repository.Save(white, black)
+}
+
+func (user *UserProfile) recordRating(category RatingCategory, gameID string, rating Rating, change float64) {
+	ratings := make(map[RatingCategory]Rating, len(user.Ratings)+1)
+	for c, r := range user.Ratings {
+		ratings[c] = r
+	}
+	ratings[category] = rating
+	user.Ratings = ratings
+
+	history := append([]RatingHistoryEntry{}, user.RatingHistory...)
+	history = append(history, RatingHistoryEntry{
+		Category:  category,
+		GameID:    gameID,
+		Rating:    rating.Rating,
+		Deviation: rating.Deviation,
+		Change:    change,
+		Date:      rating.UpdatedAt,
+	})
+	if len(history) > maxRatingHistory {
+		history = history[len(history)-maxRatingHistory:]
+	}
+	user.RatingHistory = history
+}
+
+// Score des blancs pour un résultat PGN, si la partie doit être classée
+func whiteScoreFor(result string) (float64, bool) {
+	switch result {
+	case "1-0":
+		return 1, true
+	case "0-1":
+		return 0, true
+	case "1/2-1/2":
+		return 0.5, true
+	}
+	return 0, false
+}
+
+func UserRatingsHandler(userStore *UserStore) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := userStore.GetUser(mux.Vars(r)["name"])
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		ratings := make(map[RatingCategory]Rating)
+		for _, category := range ratingCategories {
+			ratings[category] = user.RatingFor(category)
+		}
+
+		history := make([]RatingHistoryEntry, 0, len(user.RatingHistory))
+		category := RatingCategory(r.URL.Query().Get("category"))
+		for _, entry := range user.RatingHistory {
+			if category == "" || entry.Category == category {
+				history = append(history, entry)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"username": user.UserName,
+			"ratings":  ratings,
+			"history":  history,
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"math"
+	"testing"
+)
+
+func closeTo(got, want, tolerance float64) bool {
+	return math.Abs(got-want) <= tolerance
+}
+
+// Valeurs de référence calculées avec l'algorithme de Glickman, pour les
+// adversaires de son exemple, chaque partie prise séparément
+func TestUpdateGlicko2(t *testing.T) {
+	player := Rating{Rating: 1500, Deviation: 200, Volatility: 0.06}
+	tests := []struct {
+		name      string
+		opponent  Rating
+		score     float64
+		rating    float64
+		deviation float64
+	}{
+		{"win against a lower rating", Rating{Rating: 1400, Deviation: 30, Volatility: 0.06}, 1, 1563.564, 175.403},
+		{"loss against a higher rating", Rating{Rating: 1550, Deviation: 100, Volatility: 0.06}, 0, 1426.686, 175.903},
+		{"loss against an uncertain rating", Rating{Rating: 1700, Deviation: 300, Volatility: 0.06}, 0, 1455.858, 186.983},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			updated := updateGlicko2(player, tt.opponent, tt.score)
+			if !closeTo(updated.Rating, tt.rating, 0.01) {
+				t.Errorf("rating = %.3f, want %.3f", updated.Rating, tt.rating)
+			}
+			if !closeTo(updated.Deviation, tt.deviation, 0.01) {
+				t.Errorf("deviation = %.3f, want %.3f", updated.Deviation, tt.deviation)
+			}
+			if !closeTo(updated.Volatility, 0.06, 0.0001) {
+				t.Errorf("volatility = %.6f, want about 0.06", updated.Volatility)
+			}
+			if updated.Games != player.Games+1 {
+				t.Errorf("games = %d, want %d", updated.Games, player.Games+1)
+			}
+		})
+	}
+}
+
+func TestUpdateGlicko2Symmetry(t *testing.T) {
+	white, black := NewRating(), NewRating()
+
+	winner := updateGlicko2(white, black, 1)
+	loser := updateGlicko2(black, white, 0)
+	if !closeTo(winner.Rating-initialRating, initialRating-loser.Rating, 1e-6) {
+		t.Errorf("gain %.3f and loss %.3f differ", winner.Rating-initialRating, initialRating-loser.Rating)
+	}
+	if !closeTo(winner.Rating, 1662.311, 0.01) {
+		t.Errorf("winner rating = %.3f, want 1662.311", winner.Rating)
+	}
+
+	draw := updateGlicko2(white, black, 0.5)
+	if !closeTo(draw.Rating, initialRating, 1e-6) {
+		t.Errorf("draw between equal ratings moved the rating to %.3f", draw.Rating)
+	}
+	if draw.Deviation >= white.Deviation {
+		t.Errorf("deviation did not shrink: %.3f", draw.Deviation)
+	}
+}
+
+func TestUpdateGlicko2DeviationFloor(t *testing.T) {
+	rating := Rating{Rating: 1500, Deviation: minDeviation, Volatility: 0.01}
+	opponent := Rating{Rating: 1500, Deviation: minDeviation, Volatility: 0.01}
+
+	for i := 0; i < 50; i++ {
+		rating = updateGlicko2(rating, opponent, 0.5)
+	}
+	if rating.Deviation < minDeviation {
+		t.Errorf("deviation = %.3f, below the %.0f floor", rating.Deviation, minDeviation)
+	}
+}
+
+func TestApplyGameResult(t *testing.T) {
+	userStore := NewUserStore(NewMemoryUserRepository())
+	for _, user := range []UserProfile{{ID: "1", UserName: "white"}, {ID: "2", UserName: "black"}} {
+		if err := userStore.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+
+	whiteChange, blackChange, err := userStore.ApplyGameResult("game", "white", "black", CategoryBlitz, 1)
+	if err != nil {
+		t.Fatalf("ApplyGameResult: %v", err)
+	}
+	if whiteChange <= 0 || blackChange >= 0 {
+		t.Errorf("changes = %.3f / %.3f, want a gain for white and a loss for black", whiteChange, blackChange)
+	}
+
+	white, _ := userStore.GetUser("white")
+	if got := white.RatingFor(CategoryBlitz).Rating; !closeTo(got, initialRating+whiteChange, 1e-6) {
+		t.Errorf("stored blitz rating = %.3f, want %.3f", got, initialRating+whiteChange)
+	}
+	if _, rated := white.Ratings[CategoryRapid]; rated {
+		t.Errorf("rapid rating changed by a blitz game")
+	}
+	if len(white.RatingHistory) != 1 || white.RatingHistory[0].GameID != "game" {
+		t.Errorf("rating history = %+v, want one entry for the game", white.RatingHistory)
+	}
+}
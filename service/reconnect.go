@@ -0,0 +1,206 @@
+package service
+
+import (
+	"log"
+	"strconv"
+	"time"
+)
+
+// Absence d'un joueur dont la connexion a été perdue en cours de partie
+type playerAbsence struct {
+	since    time.Time
+	deadline time.Time
+	timer    *time.Timer
+}
+
+// Délai accordé à un joueur déconnecté pour revenir dans sa partie
+func reconnectGracePeriod() time.Duration {
+	seconds, err := strconv.Atoi(Getenv("RECONNECT_GRACE_SECONDS", "60"))
+	if err != nil || seconds < 1 {
+		seconds = 60
+	}
+	return time.Duration(seconds) * time.Second
+}
+
+func (rm *RoomManager) FindRoomByPlayer(username string) (*ChessGameRoom, bool) {
+	rm.mutex.RLock()
+	defer rm.mutex.RUnlock()
+
+	for _, room := range rm.rooms {
+		if room.WhitePlayer.Username == username || room.BlackPlayer.Username == username {
+			return room, true
+		}
+	}
+	return nil, false
+}
+
+func (room *ChessGameRoom) IsAbsent(username string) bool {
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	_, absent := room.absences[username]
+	return absent
+}
+
+// État complet de la partie, au format du message game_start
+func (room *ChessGameRoom) Snapshot() map[string]interface{} {
+	var clock ClockState
+	var timeControl TimeControl
+	if room.Timer != nil {
+		clock = room.Timer.State()
+		timeControl = room.Timer.TimeControl()
+	}
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	absentPlayers := make(map[string]int64)
+	for username, absence := range room.absences {
+		absentPlayers[username] = time.Until(absence.deadline).Milliseconds()
+	}
+
+	return map[string]interface{}{
+		"gameId":         room.RoomID,
+		"gameCreatorUid": room.GameCreatorUID,
+		"positonFen":     room.PositionFEN,
+		"winnerId":       room.WinnerID,
+		"whitesTime":     formatTime(int(clock.WhiteTime / time.Second)),
+		"blacksTime":     formatTime(int(clock.BlackTime / time.Second)),
+		"whiteTimeMs":    clock.WhiteTime.Milliseconds(),
+		"blackTimeMs":    clock.BlackTime.Milliseconds(),
+		"isWhitesTurn":   clock.IsWhitesTurn,
+		"isGameOver":     room.IsGameOver,
+		"moves":          append([]Move{}, room.Moves...),
+		"timeControl":    timeControl,
+		"whitePlayer":    room.WhitePlayer,
+		"blackPlayer":    room.BlackPlayer,
+		"absentPlayers":  absentPlayers,
+	}
+}
+
+// Garde la partie ouverte après la perte de connexion d'un joueur : l'adversaire
+// est prévenu et la partie n'est adjugée qu'à l'expiration du délai
+func (m *OnlineUsersManager) markPlayerAbsent(room *ChessGameRoom, username string, conn *SafeConn) {
+	grace := reconnectGracePeriod()
+	now := time.Now()
+
+	room.mutex.Lock()
+	// Le joueur a pu se reconnecter depuis la perte de cette connexion
+	if current, exists := room.Connections[username]; !exists || current != conn {
+		room.mutex.Unlock()
+		return
+	}
+	delete(room.Connections, username)
+	if _, absent := room.absences[username]; absent {
+		room.mutex.Unlock()
+		return
+	}
+	absence := m.awaitPlayer(room, username, now, grace)
+	room.mutex.Unlock()
+
+	log.Printf("Player %s disconnected from game %s, waiting %v for reconnection", username, room.RoomID, grace)
+
+	room.BroadcastMessage(WebSocketMessage{
+		Type: "opponent_disconnected",
+		Content: string(mustJson(map[string]interface{}{
+			"gameId":           room.RoomID,
+			"username":         username,
+			"reconnectSeconds": int(grace / time.Second),
+			"deadline":         absence.deadline.UnixMilli(),
+		})),
+	})
+}
+
+// Enregistre l'absence d'un joueur, adjugée à l'expiration du délai.
+// Appelée avec room.mutex verrouillé.
+func (m *OnlineUsersManager) awaitPlayer(room *ChessGameRoom, username string, now time.Time, grace time.Duration) *playerAbsence {
+	absence := &playerAbsence{since: now, deadline: now.Add(grace)}
+	absence.timer = time.AfterFunc(grace, func() {
+		m.adjudicateAbsence(room, username)
+	})
+	room.absences[username] = absence
+	return absence
+}
+
+// Rattache la nouvelle connexion d'un joueur à sa partie en cours et lui
+// renvoie l'état complet de la partie
+func (m *OnlineUsersManager) resumeGame(username string, conn *SafeConn) bool {
+	room, exists := m.roomManager.FindRoomByPlayer(username)
+	if !exists {
+		return false
+	}
+
+	room.mutex.Lock()
+	if room.IsGameOver {
+		room.mutex.Unlock()
+		return false
+	}
+	if absence, absent := room.absences[username]; absent {
+		absence.timer.Stop()
+		delete(room.absences, username)
+	}
+	room.Connections[username] = conn
+	// Une partie restaurée après un redémarrage reprend, pendules comprises,
+	// quand les deux joueurs sont revenus
+	resumeClock := room.restored && len(room.absences) == 0
+	if resumeClock {
+		room.restored = false
+	}
+	room.mutex.Unlock()
+
+	if resumeClock {
+		room.Timer.Start()
+		log.Printf("Both players are back in restored game %s, clocks resumed", room.RoomID)
+	}
+	m.presence.SetInRoom(username, true)
+
+	var userID string
+	opponent, _ := room.GetOtherPlayer(username)
+	if room.WhitePlayer.Username == username {
+		userID = room.WhitePlayer.ID
+	} else {
+		userID = room.BlackPlayer.ID
+	}
+
+	gameState := copyAndAddUserInfo(room.Snapshot(), userID, opponent)
+	gameState["chat"] = room.ChatHistory(ChatChannelPlayers)
+	if err := conn.WriteJSON(WebSocketMessage{
+		Type:    "game_resume",
+		Content: string(mustJson(gameState)),
+	}); err != nil {
+		log.Printf("Error sending game resume to %s: %v", username, err)
+	}
+
+	room.BroadcastMessage(WebSocketMessage{
+		Type: "opponent_reconnected",
+		Content: string(mustJson(map[string]string{
+			"gameId":   room.RoomID,
+			"username": username,
+		})),
+	})
+	log.Printf("Player %s resumed game %s", username, room.RoomID)
+	return true
+}
+
+// Le joueur n'est pas revenu à temps : la partie est perdue par abandon,
+// ou interrompue sans vainqueur si les deux joueurs sont partis
+func (m *OnlineUsersManager) adjudicateAbsence(room *ChessGameRoom, username string) {
+	room.mutex.Lock()
+	if _, absent := room.absences[username]; !absent || room.IsGameOver {
+		room.mutex.Unlock()
+		return
+	}
+	delete(room.absences, username)
+	opponent, _ := room.GetOtherPlayer(username)
+	_, opponentAbsent := room.absences[opponent]
+	room.mutex.Unlock()
+
+	outcome := GameOutcome{Reason: ReasonAbandoned}
+	if !opponentAbsent {
+		outcome.Winner = White.String()
+		if room.WhitePlayer.Username == username {
+			outcome.Winner = Black.String()
+		}
+	}
+	m.endGame(room, outcome)
+}
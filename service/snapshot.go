@@ -0,0 +1,248 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+)
+
+const snapshotVersion = 1
+
+// État d'une partie en cours, suffisant pour la reprendre après un redémarrage.
+// Les invitations et la file publique, liées aux connexions, ne sont pas conservées.
+type RoomSnapshot struct {
+	RoomID              string        `json:"room_id"`
+	WhitePlayer         OnlineUser    `json:"white_player"`
+	BlackPlayer         OnlineUser    `json:"black_player"`
+	CreatedAt           time.Time     `json:"created_at"`
+	GameCreatorUID      string        `json:"game_creator_uid"`
+	PositionFEN         string        `json:"position_fen"`
+	Moves               []Move        `json:"moves"`
+	DrawOfferedBy       string        `json:"draw_offered_by,omitempty"`
+	TakebackRequestedBy string        `json:"takeback_requested_by,omitempty"`
+	ChatLog             []ChatMessage `json:"chat_log,omitempty"`
+	TimeControl         TimeControl   `json:"time_control"`
+	Clock               ClockSnapshot `json:"clock"`
+}
+
+// Pendules au moment de l'instantané
+type ClockSnapshot struct {
+	WhiteTimeMs  int64 `json:"white_time_ms"`
+	BlackTimeMs  int64 `json:"black_time_ms"`
+	IsWhitesTurn bool  `json:"is_whites_turn"`
+	Plies        int   `json:"plies"`
+}
+
+type ServerSnapshot struct {
+	Version int            `json:"version"`
+	TakenAt time.Time      `json:"taken_at"`
+	Rooms   []RoomSnapshot `json:"rooms"`
+}
+
+// Fichier des instantanés et fréquence d'écriture
+type SnapshotStore struct {
+	filename string
+	interval time.Duration
+}
+
+func NewSnapshotStore(filename string, interval time.Duration) *SnapshotStore {
+	return &SnapshotStore{filename: filename, interval: interval}
+}
+
+// SNAPSHOT_FILE (défaut snapshots/rooms.json) et SNAPSHOT_INTERVAL_SECONDS (défaut 10)
+func SetupSnapshotStore() *SnapshotStore {
+	seconds, err := strconv.Atoi(Getenv("SNAPSHOT_INTERVAL_SECONDS", "10"))
+	if err != nil || seconds < 1 {
+		seconds = 10
+	}
+	filename := Getenv("SNAPSHOT_FILE", filepath.Join("snapshots", "rooms.json"))
+	return NewSnapshotStore(filename, time.Duration(seconds)*time.Second)
+}
+
+func (store *SnapshotStore) Write(snapshot ServerSnapshot) error {
+	if err := os.MkdirAll(filepath.Dir(store.filename), 0755); err != nil {
+		return fmt.Errorf("failed to create snapshots directory: %v", err)
+	}
+	data, err := json.MarshalIndent(snapshot, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal snapshot: %v", err)
+	}
+	if err := writeFileAtomic(store.filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write snapshot: %v", err)
+	}
+	return nil
+}
+
+// Lit le dernier instantané ; aucun fichier signifie aucune partie à reprendre
+func (store *SnapshotStore) Read() (ServerSnapshot, error) {
+	data, err := os.ReadFile(store.filename)
+	if os.IsNotExist(err) {
+		return ServerSnapshot{Version: snapshotVersion}, nil
+	}
+	if err != nil {
+		return ServerSnapshot{}, fmt.Errorf("failed to read snapshot: %v", err)
+	}
+
+	var snapshot ServerSnapshot
+	if err := json.Unmarshal(data, &snapshot); err != nil {
+		return ServerSnapshot{}, fmt.Errorf("failed to decode snapshot: %v", err)
+	}
+	if snapshot.Version != snapshotVersion {
+		return ServerSnapshot{}, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+	return snapshot, nil
+}
+
+// Photographie d'une partie en cours
+func (room *ChessGameRoom) snapshot() RoomSnapshot {
+	clock := room.Timer.State()
+	timeControl := room.Timer.TimeControl()
+
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+
+	return RoomSnapshot{
+		RoomID:              room.RoomID,
+		WhitePlayer:         room.WhitePlayer,
+		BlackPlayer:         room.BlackPlayer,
+		CreatedAt:           room.CreatedAt,
+		GameCreatorUID:      room.GameCreatorUID,
+		PositionFEN:         room.PositionFEN,
+		Moves:               append([]Move{}, room.Moves...),
+		DrawOfferedBy:       room.DrawOfferedBy,
+		TakebackRequestedBy: room.TakebackRequestedBy,
+		ChatLog:             append([]ChatMessage{}, room.ChatLog...),
+		TimeControl:         timeControl,
+		Clock: ClockSnapshot{
+			WhiteTimeMs:  clock.WhiteTime.Milliseconds(),
+			BlackTimeMs:  clock.BlackTime.Milliseconds(),
+			IsWhitesTurn: clock.IsWhitesTurn,
+			Plies:        clock.Plies,
+		},
+	}
+}
+
+// Instantané de toutes les parties en cours
+func (m *OnlineUsersManager) Snapshot() ServerSnapshot {
+	snapshot := ServerSnapshot{
+		Version: snapshotVersion,
+		TakenAt: time.Now(),
+		Rooms:   make([]RoomSnapshot, 0),
+	}
+	for _, room := range m.roomManager.GetActiveRooms() {
+		room.mutex.RLock()
+		isGameOver := room.IsGameOver
+		room.mutex.RUnlock()
+		if isGameOver || room.Timer == nil {
+			continue
+		}
+		snapshot.Rooms = append(snapshot.Rooms, room.snapshot())
+	}
+	return snapshot
+}
+
+func (m *OnlineUsersManager) WriteSnapshot(store *SnapshotStore) error {
+	return store.Write(m.Snapshot())
+}
+
+// Écrit périodiquement l'état des parties en cours
+func (m *OnlineUsersManager) RunSnapshots(store *SnapshotStore) {
+	ticker := time.NewTicker(store.interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if err := m.WriteSnapshot(store); err != nil {
+			log.Printf("Error writing game snapshot: %v", err)
+		}
+	}
+}
+
+// Recrée les parties du dernier instantané. Les pendules restent arrêtées :
+// le temps passé serveur arrêté n'est décompté à personne, et elles ne
+// repartent qu'au retour des deux joueurs. Un joueur qui ne revient pas dans
+// le délai de reconnexion perd la partie, comme après une déconnexion.
+func (m *OnlineUsersManager) RestoreSnapshot(store *SnapshotStore) (int, error) {
+	snapshot, err := store.Read()
+	if err != nil {
+		return 0, err
+	}
+
+	restored := 0
+	for _, saved := range snapshot.Rooms {
+		if err := m.restoreRoom(saved); err != nil {
+			log.Printf("Warning: could not restore game %s: %v", saved.RoomID, err)
+			continue
+		}
+		restored++
+	}
+	if restored > 0 {
+		log.Printf("Restored %d games from snapshot taken at %s", restored, snapshot.TakenAt.Format(time.RFC3339))
+	}
+	return restored, nil
+}
+
+func (m *OnlineUsersManager) restoreRoom(saved RoomSnapshot) error {
+	if saved.RoomID == "" {
+		return fmt.Errorf("missing room id")
+	}
+	for _, player := range []OnlineUser{saved.WhitePlayer, saved.BlackPlayer} {
+		user, err := m.userStore.GetUser(player.Username)
+		if err != nil || user.ID != player.ID {
+			return fmt.Errorf("player %s no longer exists", player.Username)
+		}
+	}
+	if _, err := ParseFEN(saved.PositionFEN); err != nil {
+		return fmt.Errorf("invalid position: %v", err)
+	}
+	timeControl, err := normalizeTimeControl(&saved.TimeControl)
+	if err != nil {
+		return fmt.Errorf("invalid time control: %v", err)
+	}
+
+	room, err := m.roomManager.CreateRoom(InvitationMessage{
+		FromUserID:   saved.WhitePlayer.ID,
+		FromUsername: saved.WhitePlayer.Username,
+		ToUserID:     saved.BlackPlayer.ID,
+		ToUsername:   saved.BlackPlayer.Username,
+		RoomID:       saved.RoomID,
+		TimeControl:  &timeControl,
+	})
+	if err != nil {
+		return err
+	}
+	room.Timer.Restore(ClockState{
+		WhiteTime:    time.Duration(saved.Clock.WhiteTimeMs) * time.Millisecond,
+		BlackTime:    time.Duration(saved.Clock.BlackTimeMs) * time.Millisecond,
+		IsWhitesTurn: saved.Clock.IsWhitesTurn,
+		Plies:        saved.Clock.Plies,
+	})
+
+	// Les deux joueurs disposent du délai de reconnexion habituel
+	grace := reconnectGracePeriod()
+	now := time.Now()
+
+	room.mutex.Lock()
+	room.CreatedAt = saved.CreatedAt
+	room.GameCreatorUID = saved.GameCreatorUID
+	room.PositionFEN = saved.PositionFEN
+	room.IsWhitesTurn = saved.Clock.IsWhitesTurn
+	room.Moves = append([]Move{}, saved.Moves...)
+	room.DrawOfferedBy = saved.DrawOfferedBy
+	room.TakebackRequestedBy = saved.TakebackRequestedBy
+	if saved.ChatLog != nil {
+		room.ChatLog = append([]ChatMessage{}, saved.ChatLog...)
+	}
+	room.restored = true
+	for _, player := range []OnlineUser{saved.WhitePlayer, saved.BlackPlayer} {
+		m.awaitPlayer(room, player.Username, now, grace)
+	}
+	room.mutex.Unlock()
+
+	m.presence.SetInRoom(saved.WhitePlayer.Username, true)
+	m.presence.SetInRoom(saved.BlackPlayer.Username, true)
+	return nil
+}
@@ -0,0 +1,196 @@
+package service
+
+import (
+	"encoding/json"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestManager(t *testing.T, users ...UserProfile) *OnlineUsersManager {
+	t.Helper()
+	userStore := NewUserStore(NewMemoryUserRepository())
+	for _, user := range users {
+		if err := userStore.CreateUser(user); err != nil {
+			t.Fatalf("CreateUser: %v", err)
+		}
+	}
+	return NewOnlineUsersManager(userStore, NewFileGameRepository(t.TempDir()), NewTokenAuthority([]byte("secret"), time.Hour))
+}
+
+func playTestMoves(t *testing.T, room *ChessGameRoom, moves ...string) {
+	t.Helper()
+	for _, uci := range moves {
+		room.mutex.RLock()
+		mover := room.WhitePlayer.Username
+		if !room.IsWhitesTurn {
+			mover = room.BlackPlayer.Username
+		}
+		room.mutex.RUnlock()
+
+		raw, _ := json.Marshal(uci)
+		played, err := room.PlayMove(raw, mover)
+		if err != nil {
+			t.Fatalf("PlayMove(%s): %v", uci, err)
+		}
+		room.Timer.SwitchTurn()
+		white, black := room.Timer.Remaining()
+		room.SetMoveClocks(played.Record.Ply, white.Milliseconds(), black.Milliseconds())
+	}
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	alice := UserProfile{ID: "1", UserName: "alice"}
+	bob := UserProfile{ID: "2", UserName: "bob"}
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "rooms.json"), time.Minute)
+
+	before := newTestManager(t, alice, bob)
+	room, err := before.roomManager.CreateRoom(InvitationMessage{
+		FromUserID:   alice.ID,
+		FromUsername: alice.UserName,
+		ToUserID:     bob.ID,
+		ToUsername:   bob.UserName,
+		RoomID:       "game",
+		TimeControl:  &TimeControl{BaseMinutes: 5, IncrementSeconds: 3},
+	})
+	if err != nil {
+		t.Fatalf("CreateRoom: %v", err)
+	}
+	room.Timer.Start()
+	playTestMoves(t, room, "e2e4", "e7e5", "g1f3")
+	room.Timer.Stop()
+	saved := room.snapshot()
+
+	if err := before.WriteSnapshot(store); err != nil {
+		t.Fatalf("WriteSnapshot: %v", err)
+	}
+
+	after := newTestManager(t, alice, bob)
+	restored, err := after.RestoreSnapshot(store)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	if restored != 1 {
+		t.Fatalf("restored %d games, want 1", restored)
+	}
+	game, exists := after.roomManager.GetRoom("game")
+	if !exists {
+		t.Fatal("restored room not found")
+	}
+	defer after.roomManager.RemoveRoom("game")
+
+	game.mutex.RLock()
+	if game.PositionFEN != saved.PositionFEN {
+		t.Errorf("position = %q, want %q", game.PositionFEN, saved.PositionFEN)
+	}
+	if len(game.Moves) != 3 || game.Moves[2].SAN != "Nf3" {
+		t.Errorf("moves = %+v, want e4 e5 Nf3", game.Moves)
+	}
+	if game.IsWhitesTurn {
+		t.Errorf("restored game has white to move, want black")
+	}
+	if !game.restored {
+		t.Errorf("restored game is not waiting for its players")
+	}
+	for _, username := range []string{alice.UserName, bob.UserName} {
+		if _, absent := game.absences[username]; !absent {
+			t.Errorf("%s is not awaited", username)
+		}
+	}
+	game.mutex.RUnlock()
+
+	if tc := game.Timer.TimeControl(); tc != (TimeControl{BaseMinutes: 5, IncrementSeconds: 3}) {
+		t.Errorf("time control = %+v", tc)
+	}
+
+	// Les pendules restent arrêtées jusqu'au retour des joueurs
+	clock := game.Timer.State()
+	time.Sleep(50 * time.Millisecond)
+	if game.Timer.State() != clock {
+		t.Errorf("clocks are running before the players are back")
+	}
+	if clock.WhiteTime.Milliseconds() != saved.Clock.WhiteTimeMs || clock.BlackTime.Milliseconds() != saved.Clock.BlackTimeMs {
+		t.Errorf("clocks = %v / %v, want %d / %d ms", clock.WhiteTime, clock.BlackTime, saved.Clock.WhiteTimeMs, saved.Clock.BlackTimeMs)
+	}
+	if clock.IsWhitesTurn || clock.Plies != 3 {
+		t.Errorf("clock state = %+v, want black to move after 3 plies", clock)
+	}
+
+	for _, username := range []string{alice.UserName, bob.UserName} {
+		if status := after.presence.Get(username).Status; status != PresencePlaying {
+			t.Errorf("%s presence = %s, want %s", username, status, PresencePlaying)
+		}
+	}
+}
+
+func TestRestoreSnapshotSkipsInvalidGames(t *testing.T) {
+	alice := UserProfile{ID: "1", UserName: "alice"}
+	bob := UserProfile{ID: "2", UserName: "bob"}
+	m := newTestManager(t, alice, bob)
+
+	valid := RoomSnapshot{
+		RoomID:      "valid",
+		WhitePlayer: OnlineUser{ID: alice.ID, Username: alice.UserName},
+		BlackPlayer: OnlineUser{ID: bob.ID, Username: bob.UserName},
+		PositionFEN: StartingFEN,
+		TimeControl: DefaultTimeControl,
+		Clock:       ClockSnapshot{WhiteTimeMs: 60000, BlackTimeMs: 60000, IsWhitesTurn: true},
+	}
+	unknownPlayer := valid
+	unknownPlayer.RoomID = "unknown-player"
+	unknownPlayer.BlackPlayer = OnlineUser{ID: "3", Username: "carol"}
+	badPosition := valid
+	badPosition.RoomID = "bad-position"
+	badPosition.PositionFEN = "not a position"
+
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "rooms.json"), time.Minute)
+	if err := store.Write(ServerSnapshot{
+		Version: snapshotVersion,
+		TakenAt: time.Now(),
+		Rooms:   []RoomSnapshot{valid, unknownPlayer, badPosition},
+	}); err != nil {
+		t.Fatalf("Write: %v", err)
+	}
+
+	restored, err := m.RestoreSnapshot(store)
+	if err != nil {
+		t.Fatalf("RestoreSnapshot: %v", err)
+	}
+	defer m.roomManager.RemoveRoom("valid")
+	if restored != 1 {
+		t.Errorf("restored %d games, want 1", restored)
+	}
+	for _, roomID := range []string{"unknown-player", "bad-position"} {
+		if _, exists := m.roomManager.GetRoom(roomID); exists {
+			t.Errorf("invalid game %s was restored", roomID)
+		}
+	}
+
+	// Une partie déjà présente n'est pas remplacée
+	if err := m.restoreRoom(valid); err == nil {
+		t.Errorf("restoring an existing room succeeded")
+	}
+}
+
+func TestSnapshotStoreWithoutFile(t *testing.T) {
+	store := NewSnapshotStore(filepath.Join(t.TempDir(), "missing.json"), time.Minute)
+	snapshot, err := store.Read()
+	if err != nil {
+		t.Fatalf("Read: %v", err)
+	}
+	if len(snapshot.Rooms) != 0 {
+		t.Errorf("rooms = %+v, want none", snapshot.Rooms)
+	}
+}
+
+func TestStoppedClockGivesNoIncrement(t *testing.T) {
+	t.Setenv("CLOCK_GRACE_PLIES", "0")
+	timeControl := TimeControl{BaseMinutes: 1, IncrementSeconds: 5}
+	timer := NewChessTimer(&ChessGameRoom{}, timeControl)
+	timer.Restore(ClockState{WhiteTime: 30 * time.Second, BlackTime: 30 * time.Second, IsWhitesTurn: true, Plies: 4})
+
+	timer.SwitchTurn()
+	if white, _ := timer.Remaining(); white != 30*time.Second {
+		t.Errorf("white time = %v after a move on stopped clocks, want 30s", white)
+	}
+}
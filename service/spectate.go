@@ -0,0 +1,192 @@
+package service
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"chess_backend/protocol"
+)
+
+const (
+	SpectateJoin    string = "spectate_join"
+	SpectateLeave   string = "spectate_leave"
+	SpectateStart   string = "spectate_start"
+	SpectatorCount  string = "spectator_count"
+	SpectateStopped string = "spectate_stopped"
+)
+
+// Partie en cours telle que listée pour les spectateurs
+type LiveGame struct {
+	ID          string      `json:"id"`
+	White       OnlineUser  `json:"white"`
+	Black       OnlineUser  `json:"black"`
+	CreatedAt   time.Time   `json:"created_at"`
+	TimeControl TimeControl `json:"time_control"`
+	FEN         string      `json:"fen"`
+	MoveCount   int         `json:"move_count"`
+	Spectators  int         `json:"spectators"`
+}
+
+func (room *ChessGameRoom) isPlayer(username string) bool {
+	return room.WhitePlayer.Username == username || room.BlackPlayer.Username == username
+}
+
+func (room *ChessGameRoom) SpectatorCount() int {
+	room.mutex.RLock()
+	defer room.mutex.RUnlock()
+	return len(room.spectators)
+}
+
+// Envoie un message aux seuls spectateurs de la partie
+func (room *ChessGameRoom) BroadcastToSpectators(message WebSocketMessage) {
+	room.mutex.RLock()
+	spectators := make(map[string]*SafeConn, len(room.spectators))
+	for username, conn := range room.spectators {
+		spectators[username] = conn
+	}
+	room.mutex.RUnlock()
+
+	for username, conn := range spectators {
+		if err := conn.WriteJSON(message); err != nil {
+			log.Printf("Error sending message to spectator %s: %v", username, err)
+			room.mutex.Lock()
+			if current, exists := room.spectators[username]; exists && current == conn {
+				delete(room.spectators, username)
+			}
+			room.mutex.Unlock()
+		}
+	}
+}
+
+// Envoie un message aux joueurs et aux spectateurs
+func (room *ChessGameRoom) BroadcastPublic(message WebSocketMessage) {
+	room.BroadcastMessage(message)
+	room.BroadcastToSpectators(message)
+}
+
+// Annonce le nombre de spectateurs à tous les participants
+func (room *ChessGameRoom) broadcastSpectatorCount() {
+	room.BroadcastPublic(WebSocketMessage{
+		Type: SpectatorCount,
+		Content: string(mustJson(map[string]interface{}{
+			"gameId":     room.RoomID,
+			"spectators": room.SpectatorCount(),
+		})),
+	})
+}
+
+// Ajoute un spectateur à une partie en cours et lui envoie l'état complet.
+// Un utilisateur ne suit qu'une partie à la fois.
+func (m *OnlineUsersManager) handleSpectateJoin(username string, conn *SafeConn, gameID string) error {
+	room, exists := m.roomManager.GetRoom(gameID)
+	if !exists {
+		return protocol.NewError(protocol.CodeRoomNotFound, "game not found")
+	}
+	if room.isPlayer(username) {
+		return protocol.NewError(protocol.CodeForbidden, "cannot spectate your own game")
+	}
+
+	room.mutex.RLock()
+	isGameOver := room.IsGameOver
+	room.mutex.RUnlock()
+	if isGameOver {
+		return protocol.NewError(protocol.CodeGameOver, "game is over")
+	}
+
+	m.roomManager.removeSpectator(username, nil)
+
+	room.mutex.Lock()
+	room.spectators[username] = conn
+	room.mutex.Unlock()
+
+	snapshot := room.Snapshot()
+	snapshot["spectators"] = room.SpectatorCount()
+	snapshot["chat"] = room.ChatHistory(ChatChannelSpectators)
+	if err := conn.WriteJSON(WebSocketMessage{
+		Type:    SpectateStart,
+		Content: string(mustJson(snapshot)),
+	}); err != nil {
+		log.Printf("Error sending spectate snapshot to %s: %v", username, err)
+	}
+
+	log.Printf("%s is spectating game %s", username, room.RoomID)
+	room.broadcastSpectatorCount()
+	return nil
+}
+
+func (m *OnlineUsersManager) handleSpectateLeave(username string, conn *SafeConn) {
+	if m.roomManager.removeSpectator(username, nil) {
+		conn.WriteJSON(WebSocketMessage{
+			Type:    SpectateStopped,
+			Content: string(mustJson(map[string]string{"username": username})),
+		})
+	}
+}
+
+// Retire un spectateur des parties qu'il suit. Si conn est fournie, seule
+// cette connexion est retirée (une connexion remplacée ne retire pas la nouvelle).
+func (rm *RoomManager) removeSpectator(username string, conn *SafeConn) bool {
+	removed := false
+	for _, room := range rm.GetActiveRooms() {
+		room.mutex.Lock()
+		current, exists := room.spectators[username]
+		if exists && (conn == nil || current == conn) {
+			delete(room.spectators, username)
+		} else {
+			exists = false
+		}
+		room.mutex.Unlock()
+
+		if exists {
+			removed = true
+			room.broadcastSpectatorCount()
+		}
+	}
+	return removed
+}
+
+// Parties en cours, des plus suivies aux moins suivies
+func (m *OnlineUsersManager) LiveGames() []LiveGame {
+	games := make([]LiveGame, 0)
+	for _, room := range m.roomManager.GetActiveRooms() {
+		var timeControl TimeControl
+		if room.Timer != nil {
+			timeControl = room.Timer.TimeControl()
+		}
+
+		room.mutex.RLock()
+		if !room.IsGameOver {
+			games = append(games, LiveGame{
+				ID:          room.RoomID,
+				White:       room.WhitePlayer,
+				Black:       room.BlackPlayer,
+				CreatedAt:   room.CreatedAt,
+				TimeControl: timeControl,
+				FEN:         room.PositionFEN,
+				MoveCount:   len(room.Moves),
+				Spectators:  len(room.spectators),
+			})
+		}
+		room.mutex.RUnlock()
+	}
+
+	sort.Slice(games, func(i, j int) bool {
+		if games[i].Spectators != games[j].Spectators {
+			return games[i].Spectators > games[j].Spectators
+		}
+		return games[i].CreatedAt.After(games[j].CreatedAt)
+	})
+	return games
+}
+
+func LiveGamesHandler(onlineUsersManager *OnlineUsersManager) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"games": onlineUsersManager.LiveGames(),
+		})
+	}
+}
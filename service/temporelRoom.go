@@ -3,6 +3,8 @@ package service
 import (
 	"sync"
 	"time"
+
+	"chess_backend/protocol"
 )
 
 type TempRoom struct {
@@ -11,6 +13,7 @@ type TempRoom struct {
 	CreatedAt   time.Time
 	WhitePlayer OnlineUser
 	BlackPlayer OnlineUser
+	TimeControl *TimeControl
 }
 
 type TemporaryRoomManager struct {
@@ -24,10 +27,14 @@ func NewTemporaryRoomManager() *TemporaryRoomManager {
 	}
 }
 
-func (trm *TemporaryRoomManager) CreateTempRoom(invitation InvitationMessage, timeout *InvitationTimeout) *TempRoom {
+func (trm *TemporaryRoomManager) CreateTempRoom(invitation InvitationMessage, timeout *InvitationTimeout) (*TempRoom, error) {
 	trm.mutex.Lock()
 	defer trm.mutex.Unlock()
 
+	if _, exists := trm.rooms[invitation.RoomID]; exists {
+		return nil, protocol.Errorf(protocol.CodeRoomExists, "invitation %s already pending", invitation.RoomID)
+	}
+
 	tempRoom := &TempRoom{
 		RoomID:  invitation.RoomID,
 		Timeout: timeout,
@@ -39,11 +46,12 @@ func (trm *TemporaryRoomManager) CreateTempRoom(invitation InvitationMessage, ti
 			ID:       invitation.ToUserID,
 			Username: invitation.ToUsername,
 		},
-		CreatedAt: time.Now(),
+		CreatedAt:   time.Now(),
+		TimeControl: invitation.TimeControl,
 	}
 
 	trm.rooms[invitation.RoomID] = tempRoom
-	return tempRoom
+	return tempRoom, nil
 }
 
 func (trm *TemporaryRoomManager) RemoveTempRoom(roomID string) {
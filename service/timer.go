@@ -2,33 +2,143 @@ package service
 
 import (
 	"fmt"
+	"strconv"
 	"sync"
 	"time"
 )
 
+type DelayType string
+
+const (
+	// Bronstein : le temps consommé est rendu, dans la limite du délai
+	DelayBronstein DelayType = "bronstein"
+	// Simple (US) : la pendule ne tourne qu'une fois le délai écoulé
+	DelaySimple DelayType = "simple"
+
+	maxTimeControlMinutes = 180
+	maxTimeControlSeconds = 180
+)
+
+// Cadence de jeu : temps de base, incrément Fischer et délai
+type TimeControl struct {
+	BaseMinutes      int       `json:"base_minutes"`
+	IncrementSeconds int       `json:"increment_seconds"`
+	DelaySeconds     int       `json:"delay_seconds"`
+	DelayType        DelayType `json:"delay_type,omitempty"`
+}
+
+var DefaultTimeControl = TimeControl{BaseMinutes: 10}
+
+func (tc TimeControl) Validate() error {
+	if tc.BaseMinutes < 1 || tc.BaseMinutes > maxTimeControlMinutes {
+		return fmt.Errorf("base time must be between 1 and %d minutes", maxTimeControlMinutes)
+	}
+	if tc.IncrementSeconds < 0 || tc.IncrementSeconds > maxTimeControlSeconds {
+		return fmt.Errorf("increment must be between 0 and %d seconds", maxTimeControlSeconds)
+	}
+	if tc.DelaySeconds < 0 || tc.DelaySeconds > maxTimeControlSeconds {
+		return fmt.Errorf("delay must be between 0 and %d seconds", maxTimeControlSeconds)
+	}
+	if tc.DelaySeconds > 0 && tc.DelayType != DelayBronstein && tc.DelayType != DelaySimple {
+		return fmt.Errorf("unknown delay type %q", tc.DelayType)
+	}
+	return nil
+}
+
+// Complète et valide une cadence reçue du client ; sans cadence, la cadence
+// par défaut est utilisée
+func normalizeTimeControl(tc *TimeControl) (TimeControl, error) {
+	if tc == nil {
+		return DefaultTimeControl, nil
+	}
+	normalized := *tc
+	if normalized.DelaySeconds > 0 && normalized.DelayType == "" {
+		normalized.DelayType = DelaySimple
+	}
+	if normalized.DelaySeconds == 0 {
+		normalized.DelayType = ""
+	}
+	return normalized, normalized.Validate()
+}
+
+// Format du tag PGN TimeControl, ex: "600+5"
+func (tc TimeControl) String() string {
+	if tc.IncrementSeconds > 0 {
+		return fmt.Sprintf("%d+%d", tc.BaseMinutes*60, tc.IncrementSeconds)
+	}
+	return fmt.Sprintf("%d", tc.BaseMinutes*60)
+}
+
+// Le timer possède seul l'état des pendules (trait et temps restant), protégé
+// par son propre verrou ; il n'écrit jamais dans la room. Ordre des verrous :
+// timer puis room. Le timer diffuse dans la room avec son verrou pris, donc
+// les pendules se lisent toujours avant de verrouiller la room.
 type ChessTimer struct {
 	room         *ChessGameRoom
 	ticker       *time.Ticker
 	stopChan     chan struct{}
 	mutex        sync.RWMutex
 	isRunning    bool
-	whiteSeconds int
-	blackSeconds int
+	timeControl  TimeControl
+	isWhitesTurn bool
+	// Temps restant au début du tour en cours
+	whiteRemaining time.Duration
+	blackRemaining time.Duration
+	// Instant (monotone) du début du tour en cours
+	turnStartedAt time.Time
+	lastBroadcast time.Time
+	// Les pendules ne tournent qu'après les premiers demi-coups ; d'ici là,
+	// chaque joueur dispose d'un délai pour jouer avant l'annulation de la partie
+	gracePlies        int
+	pliesPlayed       int
+	firstMoveDeadline time.Duration
+}
+
+const (
+	timerTickInterval      = 100 * time.Millisecond
+	timerBroadcastInterval = time.Second
+)
+
+// Nombre de demi-coups avant le démarrage des pendules (1 : après le premier
+// coup des blancs, 2 : après celui des noirs) et délai pour jouer ce premier coup
+func clockGraceSettings() (int, time.Duration) {
+	gracePlies, err := strconv.Atoi(Getenv("CLOCK_GRACE_PLIES", "1"))
+	if err != nil || gracePlies < 0 || gracePlies > 2 {
+		gracePlies = 1
+	}
+	deadlineSeconds, err := strconv.Atoi(Getenv("FIRST_MOVE_DEADLINE_SECONDS", "30"))
+	if err != nil || deadlineSeconds < 1 {
+		deadlineSeconds = 30
+	}
+	return gracePlies, time.Duration(deadlineSeconds) * time.Second
 }
 
 type TimerUpdate struct {
-	RoomID       string `json:"roomId"`
-	WhiteTime    int    `json:"whiteTime"`
-	BlackTime    int    `json:"blackTime"`
-	IsWhitesTurn bool   `json:"isWhitesTurn"`
+	RoomID       string      `json:"roomId"`
+	WhiteTime    int         `json:"whiteTime"`
+	BlackTime    int         `json:"blackTime"`
+	WhiteTimeMs  int64       `json:"whiteTimeMs"`
+	BlackTimeMs  int64       `json:"blackTimeMs"`
+	ServerTime   int64       `json:"serverTime"`
+	IsWhitesTurn bool        `json:"isWhitesTurn"`
+	TimeControl  TimeControl `json:"timeControl"`
+	ClockRunning bool        `json:"clockRunning"`
+	// Temps restant pour jouer le premier coup, tant que les pendules sont arrêtées
+	FirstMoveDeadlineMs int64 `json:"firstMoveDeadlineMs,omitempty"`
 }
 
-func NewChessTimer(room *ChessGameRoom, initialTimeMinutes int) *ChessTimer {
+func NewChessTimer(room *ChessGameRoom, timeControl TimeControl) *ChessTimer {
+	initial := time.Duration(timeControl.BaseMinutes) * time.Minute
+	gracePlies, firstMoveDeadline := clockGraceSettings()
 	return &ChessTimer{
-		room:         room,
-		stopChan:     make(chan struct{}),
-		whiteSeconds: initialTimeMinutes * 60,
-		blackSeconds: initialTimeMinutes * 60,
+		room:              room,
+		stopChan:          make(chan struct{}),
+		timeControl:       timeControl,
+		isWhitesTurn:      true,
+		whiteRemaining:    initial,
+		blackRemaining:    initial,
+		gracePlies:        gracePlies,
+		firstMoveDeadline: firstMoveDeadline,
 	}
 }
 
@@ -39,42 +149,100 @@ func (ct *ChessTimer) Start() {
 		return
 	}
 
+	// Stop ferme le canal : un timer redémarré en a besoin d'un nouveau
 	ct.isRunning = true
-	ct.ticker = time.NewTicker(1 * time.Second)
+	ct.turnStartedAt = time.Now()
+	ct.stopChan = make(chan struct{})
+	ct.ticker = time.NewTicker(timerTickInterval)
+	ticker, stopChan := ct.ticker, ct.stopChan
 	ct.mutex.Unlock()
 
-	go ct.runTimer()
+	go ct.runTimer(ticker, stopChan)
+}
+
+// Temps réellement décompté pour une durée de réflexion donnée
+func (ct *ChessTimer) chargeable(elapsed time.Duration) time.Duration {
+	if ct.timeControl.DelayType == DelaySimple {
+		// Délai simple : la pendule ne tourne qu'une fois le délai écoulé
+		elapsed -= time.Duration(ct.timeControl.DelaySeconds) * time.Second
+		if elapsed < 0 {
+			return 0
+		}
+	}
+	return elapsed
+}
+
+// Indique si les pendules attendent encore les premiers coups
+func (ct *ChessTimer) inGrace() bool {
+	return ct.pliesPlayed < ct.gracePlies
+}
+
+// Temps restant avant l'annulation de la partie faute de premier coup
+func (ct *ChessTimer) firstMoveTimeLeft(now time.Time) time.Duration {
+	left := ct.firstMoveDeadline - now.Sub(ct.turnStartedAt)
+	if left < 0 {
+		return 0
+	}
+	return left
 }
 
-func (ct *ChessTimer) runTimer() {
+// Temps restant des deux joueurs à l'instant donné (appelant verrouillé)
+func (ct *ChessTimer) remainingAt(now time.Time) (time.Duration, time.Duration) {
+	white, black := ct.whiteRemaining, ct.blackRemaining
+	if !ct.isRunning || ct.inGrace() {
+		return white, black
+	}
+	spent := ct.chargeable(now.Sub(ct.turnStartedAt))
+	if ct.isWhitesTurn {
+		white -= spent
+	} else {
+		black -= spent
+	}
+	if white < 0 {
+		white = 0
+	}
+	if black < 0 {
+		black = 0
+	}
+	return white, black
+}
+
+func (ct *ChessTimer) runTimer(ticker *time.Ticker, stopChan chan struct{}) {
 	for {
 		select {
-		case <-ct.ticker.C:
+		case now := <-ticker.C:
 			ct.mutex.Lock()
+			// Un tick d'avant un arrêt et un redémarrage ne compte pas
+			if ct.stopChan != stopChan {
+				ct.mutex.Unlock()
+				ticker.Stop()
+				return
+			}
+			white, black := ct.remainingAt(now)
+
+			// Premier coup non joué dans les temps : la partie est annulée
+			if ct.inGrace() && ct.firstMoveTimeLeft(now) <= 0 {
+				ct.broadcastTimeUpdate(now)
+				ct.mutex.Unlock()
+				ct.room.onlineManager.endGame(ct.room, GameOutcome{Reason: ReasonAborted})
+				return
+			}
+
+			// Vérifier si le joueur au trait a épuisé son temps
 			timeoutOccurred := false
 			var winner string
-
-			// Vérifier le timeout avant de décrémenter
-			if ct.room.IsWhitesTurn {
-				if ct.whiteSeconds <= 0 {
-					timeoutOccurred = true
-					winner = "black"
-				} else {
-					ct.whiteSeconds--
-					ct.room.WhitesTime = formatTime(ct.whiteSeconds)
-				}
-			} else {
-				if ct.blackSeconds <= 0 {
-					timeoutOccurred = true
-					winner = "white"
-				} else {
-					ct.blackSeconds--
-					ct.room.BlacksTime = formatTime(ct.blackSeconds)
-				}
+			if ct.isWhitesTurn && white <= 0 {
+				timeoutOccurred = true
+				winner = "black"
+			} else if !ct.isWhitesTurn && black <= 0 {
+				timeoutOccurred = true
+				winner = "white"
 			}
 
 			// Diffuser la mise à jour du temps
-			ct.broadcastTimeUpdate()
+			if timeoutOccurred || now.Sub(ct.lastBroadcast) >= timerBroadcastInterval {
+				ct.broadcastTimeUpdate(now)
+			}
 
 			if timeoutOccurred {
 				ct.mutex.Unlock() // Déverrouiller avant handleTimeOut
@@ -84,98 +252,168 @@ func (ct *ChessTimer) runTimer() {
 
 			ct.mutex.Unlock()
 
-		case <-ct.stopChan:
-			ct.ticker.Stop()
+		case <-stopChan:
+			ticker.Stop()
 			return
 		}
 	}
 }
 
 func (ct *ChessTimer) handleTimeOut(winner string) {
-	// Arrêter le timer
-	ct.Stop()
-
-	// Mettre à jour l'état du jeu
-	ct.room.mutex.Lock()
-	ct.room.IsGameOver = true
-	ct.room.Status = RoomStatusFinished
-	if winner == "white" {
-		ct.room.WinnerID = ct.room.WhitePlayer.ID
-	} else {
-		ct.room.WinnerID = ct.room.BlackPlayer.ID
-	}
+	ct.room.onlineManager.endGame(ct.room, GameOutcome{Winner: winner, Reason: ReasonTimeout})
+}
 
-	if ct.room != nil {
-		ct.room.mutex.RLock()
-		whiteUsername := ct.room.WhitePlayer.Username
-		blackUsername := ct.room.BlackPlayer.Username
-		ct.room.mutex.RUnlock()
+// Arrête le timer en figeant le temps restant des deux joueurs
+func (ct *ChessTimer) Stop() {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
 
-		// Nettoyer les deux joueurs
-		ct.room.onlineManager.cleanupPlayerFromPublicQueue(whiteUsername)
-		ct.room.onlineManager.cleanupPlayerFromPublicQueue(blackUsername)
+	if ct.isRunning {
+		ct.whiteRemaining, ct.blackRemaining = ct.remainingAt(time.Now())
+		close(ct.stopChan)
+		ct.ticker.Stop()
+		ct.isRunning = false
 	}
-	
-	ct.room.mutex.Unlock()
+}
+
+func (ct *ChessTimer) TimeControl() TimeControl {
+	return ct.timeControl
+}
+
+// Temps restant des blancs et des noirs
+func (ct *ChessTimer) Remaining() (time.Duration, time.Duration) {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+	return ct.remainingAt(time.Now())
+}
 
-	// S'assurer que le message de fin est envoyé avec une petite pause
-	time.Sleep(100 * time.Millisecond)
+// Indique si le joueur au trait a déjà épuisé son temps
+func (ct *ChessTimer) FlagFallen() bool {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
 
-	gameOver := map[string]interface{}{
-		"gameId":     ct.room.RoomID,
-		"winner":     winner,
-		"reason":     "timeout",
-		"whiteTime":  formatTime(ct.whiteSeconds),
-		"blackTime":  formatTime(ct.blackSeconds),
-		"winnerId":   ct.room.WinnerID,
-		"isGameOver": true,
-		"status":     string(RoomStatusFinished),
+	if ct.inGrace() {
+		return false
+	}
+	white, black := ct.remainingAt(time.Now())
+	if ct.isWhitesTurn {
+		return ct.isRunning && white <= 0
 	}
+	return ct.isRunning && black <= 0
+}
 
-	gameOverMsg := WebSocketMessage{
-		Type:    "game_over",
-		Content: string(mustJson(gameOver)),
+// Passe le trait à l'adversaire : le temps exact écoulé depuis le début du
+// tour est décompté, puis l'incrément ou le délai Bronstein est crédité
+func (ct *ChessTimer) SwitchTurn() {
+	ct.mutex.Lock()
+	defer ct.mutex.Unlock()
+
+	now := time.Now()
+	elapsed := time.Duration(0)
+	if ct.isRunning {
+		elapsed = now.Sub(ct.turnStartedAt)
 	}
+	ct.whiteRemaining, ct.blackRemaining = ct.remainingAt(now)
 
-	// Vérifier que les connexions sont toujours actives avant d'envoyer
-	ct.room.mutex.RLock()
-	if len(ct.room.Connections) > 0 {
-		ct.room.BroadcastMessage(gameOverMsg)
+	// Crédit accordé au joueur qui vient de jouer, une fois les pendules lancées ;
+	// un coup joué pendules arrêtées ne rapporte rien
+	bonus := time.Duration(ct.timeControl.IncrementSeconds) * time.Second
+	if !ct.isRunning || ct.inGrace() {
+		bonus = 0
+	} else if ct.timeControl.DelayType == DelayBronstein {
+		delay := time.Duration(ct.timeControl.DelaySeconds) * time.Second
+		if elapsed < delay {
+			bonus += elapsed
+		} else {
+			bonus += delay
+		}
 	}
-	ct.room.mutex.RUnlock()
+	if ct.isWhitesTurn {
+		ct.whiteRemaining += bonus
+	} else {
+		ct.blackRemaining += bonus
+	}
+
+	ct.pliesPlayed++
+	ct.isWhitesTurn = !ct.isWhitesTurn
+	ct.turnStartedAt = now
+	ct.broadcastTimeUpdate(now)
 }
-func (ct *ChessTimer) Stop() {
+
+// État des pendules à un demi-coup donné
+type ClockState struct {
+	WhiteTime    time.Duration
+	BlackTime    time.Duration
+	IsWhitesTurn bool
+	Plies        int
+}
+
+// Ramène les pendules à l'état enregistré lors d'un coup précédent
+func (ct *ChessTimer) Rewind(state ClockState) {
 	ct.mutex.Lock()
 	defer ct.mutex.Unlock()
 
-	if ct.isRunning {
-		close(ct.stopChan)
-		ct.ticker.Stop()
-		ct.isRunning = false
+	now := time.Now()
+	ct.whiteRemaining = state.WhiteTime
+	ct.blackRemaining = state.BlackTime
+	ct.isWhitesTurn = state.IsWhitesTurn
+	ct.pliesPlayed = state.Plies
+	ct.turnStartedAt = now
+	ct.broadcastTimeUpdate(now)
+}
+
+// État courant des pendules
+func (ct *ChessTimer) State() ClockState {
+	ct.mutex.RLock()
+	defer ct.mutex.RUnlock()
+
+	white, black := ct.remainingAt(time.Now())
+	return ClockState{
+		WhiteTime:    white,
+		BlackTime:    black,
+		IsWhitesTurn: ct.isWhitesTurn,
+		Plies:        ct.pliesPlayed,
 	}
 }
 
-func (ct *ChessTimer) SwitchTurn() {
+// Remet des pendules arrêtées dans un état enregistré, sans les démarrer
+func (ct *ChessTimer) Restore(state ClockState) {
 	ct.mutex.Lock()
 	defer ct.mutex.Unlock()
 
-	ct.room.IsWhitesTurn = !ct.room.IsWhitesTurn
-	ct.broadcastTimeUpdate()
+	if ct.isRunning {
+		return
+	}
+	ct.whiteRemaining = state.WhiteTime
+	ct.blackRemaining = state.BlackTime
+	ct.isWhitesTurn = state.IsWhitesTurn
+	ct.pliesPlayed = state.Plies
 }
 
-func (ct *ChessTimer) broadcastTimeUpdate() {
+func (ct *ChessTimer) broadcastTimeUpdate(now time.Time) {
+	white, black := ct.remainingAt(now)
+	ct.lastBroadcast = now
+
 	update := TimerUpdate{
 		RoomID:       ct.room.RoomID,
-		WhiteTime:    ct.whiteSeconds,
-		BlackTime:    ct.blackSeconds,
-		IsWhitesTurn: ct.room.IsWhitesTurn,
+		WhiteTime:    int(white / time.Second),
+		BlackTime:    int(black / time.Second),
+		WhiteTimeMs:  white.Milliseconds(),
+		BlackTimeMs:  black.Milliseconds(),
+		ServerTime:   time.Now().UnixMilli(),
+		IsWhitesTurn: ct.isWhitesTurn,
+		TimeControl:  ct.timeControl,
+		ClockRunning: ct.isRunning && !ct.inGrace(),
+	}
+	if ct.isRunning && ct.inGrace() {
+		update.FirstMoveDeadlineMs = ct.firstMoveTimeLeft(now).Milliseconds()
 	}
 	message := WebSocketMessage{
 		Type:    "time_update",
 		Content: string(mustJson(update)),
 	}
 
-	ct.room.BroadcastMessage(message)
+	ct.room.BroadcastPublic(message)
 }
 
 // Fonction utilitaire pour formater le temps en string "MM:SS"
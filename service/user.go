@@ -2,104 +2,47 @@ package service
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"os"
-	"path/filepath"
 	"strings"
 	"sync"
+	"time"
 )
 
-func NewUserStore() *UserStore {
+func NewUserStore(repository UserRepository) *UserStore {
 	return &UserStore{
-		Users: make(map[string]UserProfile),
-		mutex: sync.RWMutex{},
+		Users:      make(map[string]UserProfile),
+		repository: repository,
+		mutex:      sync.RWMutex{},
 	}
 }
 
 func (us *UserStore) Load() error {
-	filename := filepath.Join("users", "users.json")
-
-	// Vérifier si le dossier existe
-	if err := os.MkdirAll("users", 0755); err != nil {
-		return fmt.Errorf("failed to create users directory: %v", err)
-	}
-
-	// Vérifier si le fichier existe
-	_, err := os.Stat(filename)
-	if os.IsNotExist(err) {
-		// Si le fichier n'existe pas, créer un nouveau fichier vide
-		us.Users = make(map[string]UserProfile)
-		return us.Save()
-	}
-
-	// Le fichier existe, essayons de le lire
-	data, err := os.ReadFile(filename)
+	users, err := us.repository.LoadAll()
 	if err != nil {
-		return fmt.Errorf("failed to read users file: %v", err)
-	}
-
-	// Si le fichier est vide, initialiser avec une map vide
-	if len(data) == 0 {
-		us.Users = make(map[string]UserProfile)
-		return us.Save()
+		return err
 	}
 
-	// Essayer de décoder le JSON
-	var tempStore struct {
-		Users map[string]UserProfile `json:"users"`
-	}
-
-	if err := json.Unmarshal(data, &tempStore); err != nil {
-		// Si le fichier est corrompu, créer une nouvelle structure
-		log.Printf("Warning: corrupted users.json file, creating new one: %v", err)
-		us.Users = make(map[string]UserProfile)
-		return us.Save()
-	}
-
-	us.Users = tempStore.Users
+	us.mutex.Lock()
+	us.Users = users
+	us.mutex.Unlock()
 	return nil
 }
 
-func (us *UserStore) Save() error {
-	filename := filepath.Join("users", "users.json")
-
-	// Créer la structure à sauvegarder
-	tempStore := struct {
-		Users map[string]UserProfile `json:"users"`
-	}{
-		Users: us.Users,
-	}
-
-	// Encoder en JSON avec indentation
-	data, err := json.MarshalIndent(tempStore, "", "    ")
-	if err != nil {
-		return fmt.Errorf("failed to marshal users: %v", err)
-	}
-
-	// Écrire dans le fichier
-	err = os.WriteFile(filename, data, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to write users file: %v", err)
-	}
-
-	return nil
+// Écrit les modifications en attente et ferme le stockage
+func (us *UserStore) Close() error {
+	return us.repository.Close()
 }
 
 func (us *UserStore) CreateUser(user UserProfile) error {
 	us.mutex.Lock()
 	defer us.mutex.Unlock()
 
-	if _, exists := us.Users[user.UserName]; exists {
+	us.Users[user.UserName] = user
 
-		us.Users[user.UserName] = user
-	} else {
-
-		us.Users[user.UserName] = user
-	}
-
-	return us.Save()
+	return us.repository.Save(user)
 }
 
 func (us *UserStore) GetUser(username string) (*UserProfile, error) {
@@ -114,23 +57,8 @@ func (us *UserStore) GetUser(username string) (*UserProfile, error) {
 	return &user, nil
 }
 
-func (us *UserStore) UpdateUserOnlineStatus(username string, isOnline bool, isInRoom bool) error {
-	us.mutex.Lock()
-	defer us.mutex.Unlock()
-
-	user, exists := us.Users[username]
-	if !exists {
-		return fmt.Errorf("user not found")
-	}
-
-	user.IsOnline = isOnline
-	user.IsInRoom = isInRoom
-	us.Users[username] = user
-
-	return us.Save()
-}
-
-func CreateUserHandler(userStore *UserStore) http.HandlerFunc {
+// Crée un compte invité pour la partie rapide, sans mot de passe
+func CreateUserHandler(userStore *UserStore, tokenAuthority *TokenAuthority) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		var userInput struct {
 			UserName string `json:"username"`
@@ -141,9 +69,10 @@ func CreateUserHandler(userStore *UserStore) http.HandlerFunc {
 			return
 		}
 
+		// Les invités suivent les mêmes règles de nom que les comptes enregistrés
 		userInput.UserName = strings.TrimSpace(userInput.UserName)
-		if userInput.UserName == "" {
-			http.Error(w, "Username and password required", http.StatusBadRequest)
+		if err := validateUsername(userInput.UserName); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -158,23 +87,24 @@ func CreateUserHandler(userStore *UserStore) http.HandlerFunc {
 		// Créer nouvel utilisateur...
 
 		newUser := UserProfile{
-			ID:       GenerateUniqueID(),
-			UserName: userInput.UserName,
-			IsOnline: false,
-			IsInRoom: false,
+			ID:          GenerateUniqueID(),
+			UserName:    userInput.UserName,
+			AccountType: AccountGuest,
 		}
 
-		if err := userStore.CreateUser(newUser); err != nil {
+		if err := userStore.RegisterUser(newUser); errors.Is(err, ErrUsernameTaken) {
+			http.Error(w, "User already has an active session", http.StatusConflict)
+			return
+		} else if err != nil {
 			http.Error(w, err.Error(), http.StatusInternalServerError)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		json.NewEncoder(w).Encode(newUser)
+		writeSession(w, http.StatusOK, tokenAuthority, &newUser, Presence{Username: newUser.UserName, Status: PresenceOffline})
 	}
 }
 
-func GetUserHandler(userStore *UserStore) http.HandlerFunc {
+func GetUserHandler(userStore *UserStore, presence *PresenceService) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		username := r.URL.Query().Get("username")
 		user, err := userStore.GetUser(username)
@@ -184,16 +114,36 @@ func GetUserHandler(userStore *UserStore) http.HandlerFunc {
 		}
 
 		// Créer une version de la réponse sans le mot de passe
+		current := presence.Get(user.UserName)
 		response := struct {
-			ID       string `json:"id"`
-			UserName string `json:"username"`
-			IsOnline bool   `json:"isOnline"`
-			IsInRoom bool   `json:"isInRoom"`
+			ID            string                    `json:"id"`
+			UserName      string                    `json:"username"`
+			IsOnline      bool                      `json:"isOnline"`
+			IsInRoom      bool                      `json:"isInRoom"`
+			Status        PresenceStatus            `json:"status"`
+			LastSeen      time.Time                 `json:"lastSeen"`
+			AccountType   AccountType               `json:"accountType"`
+			Ratings       map[RatingCategory]Rating `json:"ratings"`
+			RatingHistory []RatingHistoryEntry      `json:"ratingHistory"`
 		}{
-			ID:       user.ID,
-			UserName: user.UserName,
-			IsOnline: user.IsOnline,
-			IsInRoom: user.IsInRoom,
+			ID:            user.ID,
+			UserName:      user.UserName,
+			IsOnline:      current.Online,
+			IsInRoom:      current.InRoom,
+			Status:        current.Status,
+			LastSeen:      current.LastSeen,
+			AccountType:   AccountRegistered,
+			Ratings:       make(map[RatingCategory]Rating),
+			RatingHistory: user.RatingHistory,
+		}
+		if user.IsGuest() {
+			response.AccountType = AccountGuest
+		}
+		for _, category := range ratingCategories {
+			response.Ratings[category] = user.RatingFor(category)
+		}
+		if response.RatingHistory == nil {
+			response.RatingHistory = []RatingHistoryEntry{}
 		}
 
 		w.Header().Set("Content-Type", "application/json")
@@ -211,16 +161,25 @@ func (us *UserStore) DeleteUser(username string) error {
 
 	delete(us.Users, username)
 
-	return us.Save()
+	return us.repository.Delete(username)
 }
 
 // Créer un nouveau handler pour la déconnexion
 func DisconnectUserHandler(userStore *UserStore, onlineUsersManager *OnlineUsersManager) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 
+		// Seul l'utilisateur authentifié peut supprimer son propre compte
+		claims, ok := AuthenticatedUser(r)
+		if !ok {
+			http.Error(w, "Authentication required", http.StatusUnauthorized)
+			return
+		}
 		username := r.URL.Query().Get("username")
 		if username == "" {
-			http.Error(w, "Username is required", http.StatusBadRequest)
+			username = claims.Username
+		}
+		if username != claims.Username {
+			http.Error(w, "Cannot disconnect another user", http.StatusForbidden)
 			return
 		}
 
@@ -240,12 +199,18 @@ func DisconnectUserHandler(userStore *UserStore, onlineUsersManager *OnlineUsers
 		onlineUsersManager.mutex.Unlock()
 
 		// Mettre à jour le statut en ligne et dans la room
-		userStore.UpdateUserOnlineStatus(username, false, false)
-
-		// Supprimer l'utilisateur
-		if err := userStore.DeleteUser(username); err != nil {
-			http.Error(w, fmt.Sprintf("Failed to delete user: %v", err), http.StatusInternalServerError)
-			return
+		onlineUsersManager.presence.Disconnect(username)
+		onlineUsersManager.presence.SetInRoom(username, false)
+
+		// Un compte enregistré est conservé ; seul un invité libère son nom
+		message := fmt.Sprintf("User %s successfully disconnected", user.UserName)
+		if user.IsGuest() {
+			if err := userStore.DeleteUser(username); err != nil {
+				http.Error(w, fmt.Sprintf("Failed to delete user: %v", err), http.StatusInternalServerError)
+				return
+			}
+			onlineUsersManager.presence.Forget(username)
+			message = fmt.Sprintf("User %s successfully disconnected and deleted", user.UserName)
 		}
 
 		// Notifier les autres utilisateurs que cet utilisateur est déconnecté
@@ -254,15 +219,39 @@ func DisconnectUserHandler(userStore *UserStore, onlineUsersManager *OnlineUsers
 		// Renvoyer une réponse de succès
 		w.WriteHeader(http.StatusOK)
 		json.NewEncoder(w).Encode(map[string]string{
-			"message": fmt.Sprintf("User %s successfully disconnected and deleted", user.UserName),
+			"message": message,
 		})
 	}
 }
 
 func SetupUserStore() *UserStore {
-	userStore := NewUserStore()
+	repository, err := SetupUserRepository()
+	if err != nil {
+		log.Fatalf("Failed to open user store: %v", err)
+	}
+	userStore := NewUserStore(repository)
 	if err := userStore.Load(); err != nil {
 		log.Printf("Warning: Error loading user store: %v", err)
 	}
+	if err := userStore.resetPresence(); err != nil {
+		log.Printf("Warning: Error resetting stale presence: %v", err)
+	}
 	return userStore
 }
+
+// La présence n'est plus persistée, mais les profils enregistrés par les
+// versions précédentes contiennent encore isnOline et isInRoom, restés à vrai
+// après un arrêt brutal. Les réécrire au démarrage efface ces indicateurs.
+func (us *UserStore) resetPresence() error {
+	us.mutex.RLock()
+	users := make([]UserProfile, 0, len(us.Users))
+	for _, user := range us.Users {
+		users = append(users, user)
+	}
+	us.mutex.RUnlock()
+
+	if len(users) == 0 {
+		return nil
+	}
+	return us.repository.Save(users...)
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+// Stockage persistant des profils utilisateur. Le UserStore garde les profils
+// en mémoire et n'écrit que ceux qui changent.
+type UserRepository interface {
+	LoadAll() (map[string]UserProfile, error)
+	Save(users ...UserProfile) error
+	Delete(username string) error
+	Close() error
+}
+
+const (
+	// Les modifications sont regroupées et écrites au plus tard après ce délai
+	userFlushDelay = 500 * time.Millisecond
+	// Sauvegardes users.json.bak.1 (la plus récente) à users.json.bak.N
+	maxUserBackups     = 3
+	userBackupInterval = 10 * time.Minute
+)
+
+var errEmptyUsersFile = errors.New("empty users file")
+
+// Fichier JSON unique {"users": {...}}. Les modifications sont regroupées
+// par un flusher en arrière-plan, puis le fichier est réécrit de façon atomique
+// après rotation des sauvegardes.
+type FileUserRepository struct {
+	filename   string
+	users      map[string]UserProfile
+	dirty      bool
+	closed     bool
+	lastBackup time.Time
+	mutex      sync.Mutex
+	// Sérialise les écritures sur disque
+	writeMutex sync.Mutex
+
+	flushRequests chan struct{}
+	done          chan struct{}
+	closeOnce     sync.Once
+}
+
+func NewFileUserRepository(filename string) *FileUserRepository {
+	repo := &FileUserRepository{
+		filename:      filename,
+		users:         make(map[string]UserProfile),
+		flushRequests: make(chan struct{}, 1),
+		done:          make(chan struct{}),
+	}
+	go repo.runFlusher()
+	return repo
+}
+
+func (repo *FileUserRepository) backupName(index int) string {
+	return fmt.Sprintf("%s.bak.%d", repo.filename, index)
+}
+
+func readUsersFile(filename string) (map[string]UserProfile, error) {
+	data, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return nil, errEmptyUsersFile
+	}
+
+	var stored struct {
+		Users map[string]UserProfile `json:"users"`
+	}
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, err
+	}
+	if stored.Users == nil {
+		stored.Users = make(map[string]UserProfile)
+	}
+	return stored.Users, nil
+}
+
+// Charge le fichier ; s'il est absent, vide ou corrompu, la sauvegarde valide
+// la plus récente est restaurée. Un fichier corrompu est mis de côté, jamais écrasé.
+func (repo *FileUserRepository) LoadAll() (map[string]UserProfile, error) {
+	// Vérifier si le dossier existe
+	if err := os.MkdirAll(filepath.Dir(repo.filename), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create users directory: %v", err)
+	}
+
+	users, err := readUsersFile(repo.filename)
+	if err == nil {
+		repo.mutex.Lock()
+		repo.users = users
+		repo.mutex.Unlock()
+		return copyUsers(users), nil
+	}
+
+	missing := os.IsNotExist(err)
+	if !missing && !errors.Is(err, errEmptyUsersFile) {
+		quarantined := fmt.Sprintf("%s.corrupt-%d", repo.filename, time.Now().Unix())
+		log.Printf("Warning: corrupted %s file (%v), moving it to %s", repo.filename, err, quarantined)
+		if renameErr := os.Rename(repo.filename, quarantined); renameErr != nil {
+			return nil, fmt.Errorf("failed to set aside corrupted users file: %v", renameErr)
+		}
+	}
+
+	users = nil
+	for i := 1; i <= maxUserBackups; i++ {
+		backup, backupErr := readUsersFile(repo.backupName(i))
+		if backupErr == nil {
+			log.Printf("Restored %d users from backup %s", len(backup), repo.backupName(i))
+			users = backup
+			break
+		}
+		if !os.IsNotExist(backupErr) {
+			log.Printf("Warning: unusable backup %s: %v", repo.backupName(i), backupErr)
+		}
+	}
+	if users == nil {
+		if !missing {
+			log.Printf("Warning: no usable backup of %s, starting with no users", repo.filename)
+		}
+		users = make(map[string]UserProfile)
+	}
+
+	// Réécrire le fichier principal immédiatement
+	repo.mutex.Lock()
+	repo.users = users
+	repo.dirty = true
+	repo.mutex.Unlock()
+	return copyUsers(users), repo.Flush()
+}
+
+func (repo *FileUserRepository) Save(users ...UserProfile) error {
+	repo.mutex.Lock()
+	for _, user := range users {
+		repo.users[user.UserName] = user
+	}
+	return repo.markDirty()
+}
+
+func (repo *FileUserRepository) Delete(username string) error {
+	repo.mutex.Lock()
+	delete(repo.users, username)
+	return repo.markDirty()
+}
+
+// Appelée avec repo.mutex verrouillé ; le libère. Après Close, l'écriture
+// est immédiate.
+func (repo *FileUserRepository) markDirty() error {
+	repo.dirty = true
+	closed := repo.closed
+	repo.mutex.Unlock()
+
+	if closed {
+		return repo.Flush()
+	}
+	repo.requestFlush()
+	return nil
+}
+
+func (repo *FileUserRepository) requestFlush() {
+	select {
+	case repo.flushRequests <- struct{}{}:
+	default:
+		// Une écriture est déjà prévue
+	}
+}
+
+// Regroupe les modifications reçues pendant userFlushDelay en une écriture
+func (repo *FileUserRepository) runFlusher() {
+	for {
+		select {
+		case <-repo.flushRequests:
+		case <-repo.done:
+			return
+		}
+
+		timer := time.NewTimer(userFlushDelay)
+		select {
+		case <-timer.C:
+		case <-repo.done:
+			timer.Stop()
+			return
+		}
+
+		if err := repo.Flush(); err != nil {
+			log.Printf("Error writing users file: %v", err)
+		}
+	}
+}
+
+// Écrit les modifications en attente
+func (repo *FileUserRepository) Flush() error {
+	repo.writeMutex.Lock()
+	defer repo.writeMutex.Unlock()
+
+	repo.mutex.Lock()
+	if !repo.dirty {
+		repo.mutex.Unlock()
+		return nil
+	}
+	data, err := json.MarshalIndent(struct {
+		Users map[string]UserProfile `json:"users"`
+	}{repo.users}, "", "    ")
+	repo.dirty = false
+	repo.mutex.Unlock()
+
+	if err == nil {
+		err = repo.write(data)
+	}
+	if err != nil {
+		// Réessayer plus tard : les modifications restent en attente
+		repo.mutex.Lock()
+		repo.dirty = true
+		closed := repo.closed
+		repo.mutex.Unlock()
+		if !closed {
+			repo.requestFlush()
+		}
+		return err
+	}
+	return nil
+}
+
+// Appelée avec writeMutex verrouillé
+func (repo *FileUserRepository) write(data []byte) error {
+	if time.Since(repo.lastBackup) >= userBackupInterval {
+		if backedUp, err := repo.rotateBackups(); err != nil {
+			log.Printf("Warning: failed to back up %s: %v", repo.filename, err)
+		} else if backedUp {
+			repo.lastBackup = time.Now()
+		}
+	}
+
+	if err := writeFileAtomic(repo.filename, data, 0644); err != nil {
+		return fmt.Errorf("failed to write users file: %v", err)
+	}
+	return nil
+}
+
+// Décale les sauvegardes et copie le fichier actuel en users.json.bak.1.
+// Retourne false s'il n'y avait pas encore de fichier à sauvegarder.
+func (repo *FileUserRepository) rotateBackups() (bool, error) {
+	current, err := os.ReadFile(repo.filename)
+	if os.IsNotExist(err) || (err == nil && len(current) == 0) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	os.Remove(repo.backupName(maxUserBackups))
+	for i := maxUserBackups - 1; i >= 1; i-- {
+		if err := os.Rename(repo.backupName(i), repo.backupName(i+1)); err != nil && !os.IsNotExist(err) {
+			return false, err
+		}
+	}
+	return true, writeFileAtomic(repo.backupName(1), current, 0644)
+}
+
+// Arrête le flusher et écrit les modifications en attente
+func (repo *FileUserRepository) Close() error {
+	repo.closeOnce.Do(func() {
+		repo.mutex.Lock()
+		repo.closed = true
+		repo.mutex.Unlock()
+		close(repo.done)
+	})
+	return repo.Flush()
+}
+
+var usersBucket = []byte("users")
+
+// Base clé-valeur embarquée (bbolt) : un profil JSON par clé, seuls les
+// profils modifiés sont écrits
+type BoltUserRepository struct {
+	db *bolt.DB
+}
+
+func NewBoltUserRepository(filename string) (*BoltUserRepository, error) {
+	if err := os.MkdirAll(filepath.Dir(filename), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create users directory: %v", err)
+	}
+	db, err := bolt.Open(filename, 0644, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open users database: %v", err)
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(usersBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to initialize users database: %v", err)
+	}
+	return &BoltUserRepository{db: db}, nil
+}
+
+func (repo *BoltUserRepository) LoadAll() (map[string]UserProfile, error) {
+	users := make(map[string]UserProfile)
+	err := repo.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).ForEach(func(key, value []byte) error {
+			var user UserProfile
+			if err := json.Unmarshal(value, &user); err != nil {
+				log.Printf("Warning: skipping unreadable user %s: %v", key, err)
+				return nil
+			}
+			users[string(key)] = user
+			return nil
+		})
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to read users database: %v", err)
+	}
+	return users, nil
+}
+
+// Les profils d'un même appel sont écrits dans une seule transaction
+func (repo *BoltUserRepository) Save(users ...UserProfile) error {
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(usersBucket)
+		for _, user := range users {
+			data, err := json.Marshal(user)
+			if err != nil {
+				return err
+			}
+			if err := bucket.Put([]byte(user.UserName), data); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to write users database: %v", err)
+	}
+	return nil
+}
+
+func (repo *BoltUserRepository) Delete(username string) error {
+	err := repo.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(usersBucket).Delete([]byte(username))
+	})
+	if err != nil {
+		return fmt.Errorf("failed to delete user: %v", err)
+	}
+	return nil
+}
+
+func (repo *BoltUserRepository) Close() error {
+	return repo.db.Close()
+}
+
+// Stockage en mémoire, sans persistance (tests, environnements jetables)
+type MemoryUserRepository struct {
+	users map[string]UserProfile
+	mutex sync.Mutex
+}
+
+func NewMemoryUserRepository() *MemoryUserRepository {
+	return &MemoryUserRepository{users: make(map[string]UserProfile)}
+}
+
+func (repo *MemoryUserRepository) LoadAll() (map[string]UserProfile, error) {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+	return copyUsers(repo.users), nil
+}
+
+func (repo *MemoryUserRepository) Save(users ...UserProfile) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	for _, user := range users {
+		repo.users[user.UserName] = user
+	}
+	return nil
+}
+
+func (repo *MemoryUserRepository) Delete(username string) error {
+	repo.mutex.Lock()
+	defer repo.mutex.Unlock()
+
+	delete(repo.users, username)
+	return nil
+}
+
+func (repo *MemoryUserRepository) Close() error {
+	return nil
+}
+
+func copyUsers(users map[string]UserProfile) map[string]UserProfile {
+	copied := make(map[string]UserProfile, len(users))
+	for username, user := range users {
+		copied[username] = user
+	}
+	return copied
+}
+
+// USER_STORE choisit le stockage : json (défaut, USERS_FILE), bolt (USERS_DB)
+// ou memory
+func SetupUserRepository() (UserRepository, error) {
+	backend := strings.ToLower(Getenv("USER_STORE", "json"))
+	switch backend {
+	case "json":
+		return NewFileUserRepository(Getenv("USERS_FILE", filepath.Join("users", "users.json"))), nil
+	case "bolt", "kv":
+		return NewBoltUserRepository(Getenv("USERS_DB", filepath.Join("users", "users.db")))
+	case "memory":
+		return NewMemoryUserRepository(), nil
+	}
+	return nil, fmt.Errorf("unknown USER_STORE %q (expected json, bolt or memory)", backend)
+}
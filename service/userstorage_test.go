@@ -0,0 +1,126 @@
+package service
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Crée users.json avec alice et bob, et users.json.bak.1 avec alice seule
+func writeUsersWithBackup(t *testing.T, filename string) {
+	t.Helper()
+	initial := []byte(`{"users": {"alice": {"id": "1", "username": "alice"}}}`)
+	if err := os.WriteFile(filename, initial, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewFileUserRepository(filename)
+	if _, err := repo.LoadAll(); err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	// La première écriture sauvegarde d'abord le fichier existant
+	if err := repo.Save(UserProfile{ID: "2", UserName: "bob"}); err != nil {
+		t.Fatalf("Save: %v", err)
+	}
+	if err := repo.Close(); err != nil {
+		t.Fatalf("Close: %v", err)
+	}
+	if _, err := os.Stat(repo.backupName(1)); err != nil {
+		t.Fatalf("backup not written: %v", err)
+	}
+}
+
+func TestFileUserRepositoryRoundTrip(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "users.json")
+	writeUsersWithBackup(t, filename)
+
+	users, err := NewFileUserRepository(filename).LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(users) != 2 || users["alice"].ID != "1" || users["bob"].ID != "2" {
+		t.Errorf("users = %+v, want alice and bob", users)
+	}
+}
+
+func TestFileUserRepositoryRecoversCorruptFile(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "users.json")
+	writeUsersWithBackup(t, filename)
+
+	corrupt := []byte(`{"users": {"alice": `)
+	if err := os.WriteFile(filename, corrupt, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	repo := NewFileUserRepository(filename)
+	defer repo.Close()
+	users, err := repo.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(users) != 1 || users["alice"].ID != "1" {
+		t.Errorf("users = %+v, want alice from the backup", users)
+	}
+
+	// Le fichier corrompu est mis de côté tel quel
+	quarantined, _ := filepath.Glob(filename + ".corrupt-*")
+	if len(quarantined) != 1 {
+		t.Fatalf("quarantined files = %v, want one", quarantined)
+	}
+	if data, _ := os.ReadFile(quarantined[0]); string(data) != string(corrupt) {
+		t.Errorf("quarantined content = %q, want the corrupt file", data)
+	}
+
+	// Le fichier principal est réécrit depuis la sauvegarde
+	restored, err := readUsersFile(filename)
+	if err != nil {
+		t.Fatalf("main file not rewritten: %v", err)
+	}
+	if len(restored) != 1 || restored["alice"].ID != "1" {
+		t.Errorf("rewritten users = %+v, want alice", restored)
+	}
+}
+
+func TestFileUserRepositorySkipsUnusableBackups(t *testing.T) {
+	dir := t.TempDir()
+	filename := filepath.Join(dir, "users.json")
+	writeUsersWithBackup(t, filename)
+
+	repo := NewFileUserRepository(filename)
+	defer repo.Close()
+	if err := os.Rename(repo.backupName(1), repo.backupName(2)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(repo.backupName(1), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filename, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	users, err := repo.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(users) != 1 || users["alice"].ID != "1" {
+		t.Errorf("users = %+v, want alice from the second backup", users)
+	}
+	// Un fichier vide n'est pas mis de côté
+	if quarantined, _ := filepath.Glob(filename + ".corrupt-*"); len(quarantined) != 0 {
+		t.Errorf("empty file was quarantined: %v", quarantined)
+	}
+}
+
+func TestFileUserRepositoryStartsEmptyWithoutFile(t *testing.T) {
+	repo := NewFileUserRepository(filepath.Join(t.TempDir(), "users", "users.json"))
+	defer repo.Close()
+
+	users, err := repo.LoadAll()
+	if err != nil {
+		t.Fatalf("LoadAll: %v", err)
+	}
+	if len(users) != 0 {
+		t.Errorf("users = %+v, want none", users)
+	}
+}
@@ -1,13 +1,13 @@
 package service
 
 import (
-	"encoding/json"
-	"fmt"
 	"log"
 	"net/http"
 	"time"
 
 	"github.com/gorilla/websocket"
+
+	"chess_backend/protocol"
 )
 
 // Configuration du WebSocket upgrader
@@ -17,33 +17,48 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
-func NewOnlineUsersManager(userStore *UserStore) *OnlineUsersManager {
+func NewOnlineUsersManager(userStore *UserStore, gameRepository GameRepository, tokenAuthority *TokenAuthority) *OnlineUsersManager {
 	manager := &OnlineUsersManager{
-		connections: make(map[string]*SafeConn),
-		userStore:   userStore,
+		connections:    make(map[string]*SafeConn),
+		userStore:      userStore,
+		gameRepository: gameRepository,
+		tokenAuthority: tokenAuthority,
+		presence:       NewPresenceService(),
 		publicQueue: &PublicGameQueue{
-			waitingPlayers: make(map[string]*QueuedPlayer),
+			waitingPlayers:  make(map[string]*QueuedPlayer),
+			recentOpponents: make(map[string]recentPairing),
+			averageWait:     make(map[TimeControl]time.Duration),
 		},
 	}
 	// Créer le RoomManager avec une référence à l'OnlineUsersManager
 	manager.roomManager = NewRoomManager(manager)
 	manager.tempRoomManager = NewTemporaryRoomManager()
+	manager.handlers = manager.newHandlerRegistry()
+
+	// Lancer l'appariement des joueurs de la file publique
+	go manager.runMatchmaker()
 	return manager
 }
 
+// Présence des utilisateurs connectés
+func (m *OnlineUsersManager) Presence() *PresenceService {
+	return m.presence
+}
+
 // Gérer la connexion WebSocket
 func (m *OnlineUsersManager) HandleConnection(w http.ResponseWriter, r *http.Request) {
-	// Récupérer le nom d'utilisateur
-	username := r.URL.Query().Get("username")
-	if username == "" {
-		http.Error(w, "Username is required", http.StatusBadRequest)
+	// L'identité vient du jeton de session, jamais d'un paramètre du client
+	claims, err := m.tokenAuthority.AuthenticateWebSocket(r, m.userStore)
+	if err != nil {
+		w.Header().Set("WWW-Authenticate", "Bearer")
+		http.Error(w, err.Error(), http.StatusUnauthorized)
 		return
 	}
+	username := claims.Username
 
-	// Vérifier si l'utilisateur existe
-	_, err := m.userStore.GetUser(username)
-	if err != nil {
-		http.Error(w, "User not found", http.StatusUnauthorized)
+	// Le paramètre username, encore envoyé par les anciens clients, doit correspondre
+	if requested := r.URL.Query().Get("username"); requested != "" && requested != username {
+		http.Error(w, "Token does not match username", http.StatusForbidden)
 		return
 	}
 
@@ -56,221 +71,100 @@ func (m *OnlineUsersManager) HandleConnection(w http.ResponseWriter, r *http.Req
 
 	safeConn := NewSafeConn(conn)
 
-	// Ajouter la connexion
+	// Ajouter la connexion, en fermant une éventuelle connexion précédente.
+	// Le statut en ligne change sous le même verrou que la connexion, pour
+	// qu'une ancienne connexion en cours de fermeture ne l'écrase pas.
 	m.mutex.Lock()
-	// m.connections[username] = conn
+	previous, hadPrevious := m.connections[username]
 	m.connections[username] = safeConn
+	m.presence.Connect(username)
 	m.mutex.Unlock()
+	if hadPrevious {
+		previous.conn.Close()
+	}
 
-	// Mettre à jour le statut en ligne
-	m.userStore.UpdateUserOnlineStatus(username, true, false)
+	// Reprendre la partie en cours si le joueur s'était déconnecté
+	m.resumeGame(username, safeConn)
 
 	// Notifier tous les clients de la nouvelle connexion
 	m.broadcastOnlineUsers()
 
 	// Gestion de la connexion
-	go m.handleClientConnection(username, conn)
+	go m.handleClientConnection(username, safeConn)
 
 }
 
 // Gérer les messages du client
-func (m *OnlineUsersManager) handleClientConnection(username string, conn *websocket.Conn) {
+func (m *OnlineUsersManager) handleClientConnection(username string, conn *SafeConn) {
 
 	defer func() {
-		// Trouver et nettoyer la room si l'utilisateur y était
-		var roomID string
-		m.roomManager.mutex.RLock()
-		for _, room := range m.roomManager.rooms {
-			if room.WhitePlayer.Username == username || room.BlackPlayer.Username == username {
-				roomID = room.RoomID
-				break
-			}
+		conn.conn.Close()
+		m.roomManager.removeSpectator(username, conn)
+
+		// Une connexion fermée ne peut plus recevoir de partie publique
+		m.removeQueuedConnection(username, conn)
+
+		// Une connexion remplacée par une reconnexion n'a rien à nettoyer
+		m.mutex.RLock()
+		current, exists := m.connections[username]
+		m.mutex.RUnlock()
+		if exists && current != conn {
+			return
 		}
-		m.roomManager.mutex.RUnlock()
-
-		if roomID != "" {
-			// Notifier l'autre joueur et nettoyer la room
-			invitation := InvitationMessage{
-				Type:         RoomLeave,
-				FromUsername: username,
-				RoomID:       roomID,
+
+		// Une partie en cours reste ouverte le temps que le joueur se reconnecte ;
+		// une partie terminée est nettoyée immédiatement
+		if room, found := m.roomManager.FindRoomByPlayer(username); found {
+			room.mutex.RLock()
+			isGameOver := room.IsGameOver
+			room.mutex.RUnlock()
+
+			if isGameOver {
+				invitation := InvitationMessage{
+					Type:         RoomLeave,
+					FromUsername: username,
+					RoomID:       room.RoomID,
+				}
+				m.handleInvitation(invitation)
+			} else {
+				m.markPlayerAbsent(room, username, conn)
 			}
-			m.handleInvitation(invitation)
 		}
 
-		// Nettoyer la connexion
+		// Nettoyer la connexion et mettre à jour le statut hors ligne, sauf si
+		// le joueur s'est reconnecté entre-temps ; une partie en attente de
+		// reconnexion reste marquée en cours
 		m.mutex.Lock()
-		delete(m.connections, username)
+		if current, exists := m.connections[username]; exists && current == conn {
+			delete(m.connections, username)
+			m.presence.Disconnect(username)
+		}
 		m.mutex.Unlock()
 
-		// Mettre à jour le statut hors ligne
-		m.userStore.UpdateUserOnlineStatus(username, false, false)
-		m.userStore.UpdateUserRoomStatus(username, false)
-
 		// Notifier les autres clients
 		m.broadcastOnlineUsers()
-
-		conn.Close()
 	}()
 
+	session := &clientSession{username: username, conn: conn}
 	for {
-		var message WebSocketMessage
-		err := conn.ReadJSON(&message)
+		_, data, err := conn.conn.ReadMessage()
 		if err != nil {
 			log.Printf("WebSocket read error for %s: %v", username, err)
 			break
 		}
+		m.presence.Touch(username)
 
-		switch message.Type {
-		case "request_online_users":
-
-			onlineUsers := m.getCurrentOnlineUsers()
-			conn.WriteJSON(WebSocketMessage{
-				Type:    "online_users",
-				Content: string(mustJson(onlineUsers)),
-			})
-
-		case "invitation_send", "invitation_accept", "invitation_reject", "invitation_cancel", "room_leave":
-			var invitation InvitationMessage
-			if err := json.Unmarshal([]byte(message.Content), &invitation); err != nil {
-				log.Printf("Error parsing invitation: %v", err)
-				continue
-			}
-
-			if err := m.handleInvitation(invitation); err != nil {
-				log.Printf("Failed to process invitation: %v", err)
-			}
-			m.broadcastOnlineUsers()
-
-		case "leave_room":
-			var leaveRequest struct {
-				Username string `json:"username"`
-			}
-			if err := json.Unmarshal([]byte(message.Content), &leaveRequest); err != nil {
-				log.Printf("Error parsing leave room request: %v", err)
-				continue
-			}
-
-			m.cleanupPlayerFromPublicQueue(username)
-
-			_, err := m.RemoveUserFromRoom(leaveRequest.Username)
-			if err != nil {
-				log.Printf("Error removing user from room: %v", err)
-				continue
-			}
-
-			// Notify all clients about updated online users
-			m.broadcastOnlineUsers()
-
-			// moves
-		case "game_move":
-			var moveData struct {
-				GameID       string      `json:"gameId"`
-				FromUserID   string      `json:"fromUserId"`
-				ToUserID     string      `json:"toUserId"`
-				ToUsername   string      `json:"toUsername"`
-				Move         interface{} `json:"move"`
-				FEN          string      `json:"fen"`
-				IsWhitesTurn bool        `json:"isWhitesTurn"`
-			}
-
-			if err := json.Unmarshal([]byte(message.Content), &moveData); err != nil {
-				log.Printf("Error parsing move data: %v", err)
-				continue
-			}
-
-			// Récupérer la room
-			room, exists := m.roomManager.GetRoom(moveData.GameID)
-			if !exists {
-				log.Printf("Room not found: %s", moveData.GameID)
-				continue
-			}
-			if exists {
-				room.Timer.SwitchTurn()
-			}
-
-			// Mettre à jour l'état du jeu
-			room.mutex.Lock()
-			room.PositionFEN = moveData.FEN
-			room.IsWhitesTurn = moveData.IsWhitesTurn
-			room.mutex.Unlock()
-
-			// Envoyer le mouvement à l'autre joueur
-			if otherConn, exists := room.Connections[moveData.ToUsername]; exists {
-				if err := otherConn.WriteJSON(WebSocketMessage{
-					Type:    "game_move",
-					Content: message.Content,
-				}); err != nil {
-					log.Printf("Error sending move to other player: %v", err)
-				}
-			} else {
-				log.Printf("Connection not found for player %s", moveData.ToUsername)
-			}
-		case "game_over_checkmate":
-			var gameOverData struct {
-				GameID   string `json:"gameId"`
-				Winner   string `json:"winner"`
-				Reason   string `json:"reason"`
-				WinnerID string `json:"winnerId"`
-			}
-
-			if err := json.Unmarshal([]byte(message.Content), &gameOverData); err != nil {
-				log.Printf("Error parsing Partie Terminée data: %v", err)
-				continue
-			}
-
-			m.cleanupPlayerFromPublicQueue(username)
-
-			// Récupérer la room
-			room, exists := m.roomManager.GetRoom(gameOverData.GameID)
-			if !exists {
-				log.Printf("Room not found: %s", gameOverData.GameID)
-				continue
-			}
-
-			room.IsGameOver = true
-
-			gameOverMessage := WebSocketMessage{
-				Type:    "game_over_checkmate",
-				Content: message.Content,
-			}
-
-			// Envoyer aux deux joueurs
-			for username, conn := range room.Connections {
-				if err := conn.WriteJSON(gameOverMessage); err != nil {
-					log.Printf("Error sending Partie Terminée notification to %s: %v", username, err)
-				}
-			}
-
-			// Arrêter le timer si nécessaire
-			if room.Timer != nil {
-				room.Timer.Stop()
-			}
-
-			//  Nettoyer la room après un délai 2 secondes
-			go func() {
-				time.Sleep(2 * time.Second)
-				m.roomManager.RemoveRoom(gameOverData.GameID)
-				for username := range room.Connections {
-					m.userStore.UpdateUserRoomStatus(username, false)
-				}
-				m.broadcastOnlineUsers()
-			}()
-
-		case PublicGameRequest:
-			user, err := m.userStore.GetUser(username)
-			if err != nil {
-				continue
-			}
-			safeConn := NewSafeConn(conn)
-			m.handlePublicGameRequest(username, user.ID, safeConn)
-
-		case PublicQueueLeave:
-			m.handlePublicQueueLeave(username)
-
-		default:
-			log.Printf("Unhandled message type: %s", message.Type)
-			m.broadcastOnlineUsers()
+		// Chaque message reçoit une erreur en cas d'échec, et un ack s'il
+		// porte un identifiant
+		envelope, err := protocol.DecodeEnvelope(data)
+		if err == nil {
+			err = m.handlers.Dispatch(session, conn.Version(), envelope)
+		}
+		if err != nil {
+			log.Printf("Failed to process %s from %s: %v", envelope.Type, username, err)
+			sendError(conn, envelope.ID, err)
+		} else if envelope.ID != "" {
+			sendAck(conn, envelope.ID, protocol.CanonicalType(envelope.Type))
 		}
 	}
 }
@@ -282,16 +176,29 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 	m.mutex.RUnlock()
 
 	if invitation.Type == RoomLeave && !fromExists {
-		return fmt.Errorf("user not online")
+		return protocol.NewError(protocol.CodeUserOffline, "user not online")
 	}
 
 	if invitation.Type != RoomLeave && (!fromExists || !toExists) {
-		return fmt.Errorf("one or both users not online")
+		return protocol.NewError(protocol.CodeUserOffline, "one or both users not online")
 	}
 
 	switch invitation.Type {
 
 	case InvitationSend:
+		// Valider la cadence proposée
+		timeControl, err := normalizeTimeControl(invitation.TimeControl)
+		if err != nil {
+			return protocol.Errorf(protocol.CodeInvalidPayload, "invalid time control: %v", err)
+		}
+		invitation.TimeControl = &timeControl
+
+		// L'identifiant ne doit désigner ni une partie en cours ou archivée ni
+		// une autre invitation
+		if err := m.roomManager.CheckRoomID(invitation.RoomID); err != nil {
+			return err
+		}
+
 		// Créer le timer
 		timeout := NewInvitationTimeout(invitation.RoomID, 20*time.Second, func() {
 			// Fonction appelée quand le timeout expire
@@ -323,7 +230,9 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 		})
 
 		// Créer la room temporaire
-		m.tempRoomManager.CreateTempRoom(invitation, timeout)
+		if _, err := m.tempRoomManager.CreateTempRoom(invitation, timeout); err != nil {
+			return err
+		}
 		timeout.Start()
 
 		// Envoyer l'invitation
@@ -334,27 +243,28 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 			})
 			if err != nil {
 				log.Printf("Error sending invitation: %v", err)
-				return err
+				return protocol.Errorf(protocol.CodeDeliveryFailed, "could not deliver invitation: %v", err)
 			}
 		}
 
 	case InvitationAccept:
 		// Récupérer et nettoyer la room temporaire
-		if _, exists := m.tempRoomManager.GetTempRoom(invitation.RoomID); exists {
+		if tempRoom, exists := m.tempRoomManager.GetTempRoom(invitation.RoomID); exists {
 
 			m.tempRoomManager.RemoveTempRoom(invitation.RoomID)
 
+			// La cadence est celle de l'invitation d'origine
+			invitation.TimeControl = tempRoom.TimeControl
+
 			// Créer la nouvelle room de jeu
-			gameRoom := m.roomManager.CreateRoom(invitation)
+			gameRoom, err := m.roomManager.CreateRoom(invitation)
+			if err != nil {
+				return err
+			}
 
 			// Mettre à jour le statut des joueurs
-			m.userStore.UpdateUserRoomStatus(invitation.FromUsername, true)
-			m.userStore.UpdateUserRoomStatus(invitation.ToUsername, true)
-
-			// Initialiser l'état du jeu
-			gameRoom.PositionFEN = "rnbqkbnr/pppppppp/8/8/8/8/PPPPPPPP/RNBQKBNR w KQkq - 0 1"
-			gameRoom.IsWhitesTurn = true
-			gameRoom.IsGameOver = false
+			m.presence.SetInRoom(invitation.FromUsername, true)
+			m.presence.SetInRoom(invitation.ToUsername, true)
 
 			// Préparer les états de jeu pour les deux joueurs
 			baseGameState := map[string]interface{}{
@@ -367,6 +277,7 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 				"isWhitesTurn":   gameRoom.IsWhitesTurn,
 				"isGameOver":     gameRoom.IsGameOver,
 				"moves":          gameRoom.Moves,
+				"timeControl":    gameRoom.Timer.TimeControl(),
 			}
 
 			// États spécifiques pour chaque joueur
@@ -390,6 +301,7 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 					Content: string(mustJson(inviteeGameState)),
 				})
 			}
+			gameRoom.Timer.Start()
 		}
 	case InvitationReject:
 
@@ -433,7 +345,13 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 		room, exists := m.roomManager.GetRoom(invitation.RoomID)
 		if !exists {
 			log.Printf("Room %s not found during leave", invitation.RoomID)
-			return fmt.Errorf("room not found")
+			return protocol.NewError(protocol.CodeRoomNotFound, "room not found")
+		}
+
+		// endGame prévient l'adversaire et ferme la room après la fin de partie
+		if m.endGameOnLeave(room, invitation.FromUsername) {
+			m.presence.SetInRoom(invitation.FromUsername, false)
+			return nil
 		}
 
 		// Arrêter le timer avant de fermer la room
@@ -446,20 +364,49 @@ func (m *OnlineUsersManager) handleInvitation(invitation InvitationMessage) erro
 
 		// Remove the room
 		m.roomManager.RemoveRoom(invitation.RoomID)
-		m.userStore.UpdateUserRoomStatus(invitation.FromUsername, false)
+		m.presence.SetInRoom(invitation.FromUsername, false)
 
 		// If the other player is still in the room, update their status too
 		otherUsername, found := room.GetOtherPlayer(invitation.FromUsername)
 		if found {
-			m.userStore.UpdateUserRoomStatus(otherUsername, false)
+			m.presence.SetInRoom(otherUsername, false)
 		}
-		m.userStore.UpdateUserRoomStatus(invitation.FromUsername, false)
-		m.userStore.UpdateUserRoomStatus(invitation.ToUsername, false)
+		m.presence.SetInRoom(invitation.FromUsername, false)
+		m.presence.SetInRoom(invitation.ToUsername, false)
 	}
 
 	return nil
 }
 
+// Remplace l'identité annoncée par le client par celle de la connexion :
+// l'expéditeur est l'invitant pour un envoi, une annulation ou un départ, et
+// l'invité pour une acceptation ou un refus (l'invitant est alors celui de
+// l'invitation d'origine)
+func (m *OnlineUsersManager) bindInvitationIdentity(invitation *InvitationMessage, username string) {
+	var userID string
+	if user, err := m.userStore.GetUser(username); err == nil {
+		userID = user.ID
+	}
+
+	switch invitation.Type {
+	case InvitationAccept, InvitationReject:
+		invitation.ToUsername = username
+		invitation.ToUserID = userID
+		if tempRoom, exists := m.tempRoomManager.GetTempRoom(invitation.RoomID); exists {
+			invitation.FromUsername = tempRoom.WhitePlayer.Username
+			invitation.FromUserID = tempRoom.WhitePlayer.ID
+		}
+	default:
+		invitation.FromUsername = username
+		invitation.FromUserID = userID
+		if invitation.ToUsername != "" {
+			if invitee, err := m.userStore.GetUser(invitation.ToUsername); err == nil {
+				invitation.ToUserID = invitee.ID
+			}
+		}
+	}
+}
+
 func copyAndAddUserInfo(baseState map[string]interface{}, userId, opponentUsername string) map[string]interface{} {
 	newState := make(map[string]interface{})
 	for k, v := range baseState {
@@ -470,20 +417,6 @@ func copyAndAddUserInfo(baseState map[string]interface{}, userId, opponentUserna
 	return newState
 }
 
-func (us *UserStore) UpdateUserRoomStatus(username string, isInRoom bool) error {
-	us.mutex.Lock()
-	defer us.mutex.Unlock()
-
-	user, exists := us.Users[username]
-	if !exists {
-		return fmt.Errorf("user not found")
-	}
-	user.IsInRoom = isInRoom
-	us.Users[username] = user
-
-	return us.Save()
-}
-
 func (m *OnlineUsersManager) notifyRoomClosure(invitation InvitationMessage) {
 	// Try to find the room first
 	room, exists := m.roomManager.GetRoom(invitation.RoomID)
@@ -553,28 +486,10 @@ func (m *OnlineUsersManager) getCurrentOnlineUsers() []OnlineUser {
 	}
 	m.mutex.RUnlock()
 
-	// Obtenir les utilisateurs dans des rooms
-	usersInRooms := make(map[string]bool)
-	m.roomManager.mutex.RLock()
-	for _, room := range m.roomManager.rooms {
-		usersInRooms[room.WhitePlayer.Username] = true
-		usersInRooms[room.BlackPlayer.Username] = true
-	}
-	m.roomManager.mutex.RUnlock()
-
-	// Obtenir les utilisateurs dans la file d'attente publique
-	m.publicQueue.mutex.RLock()
-	usersInPublicQueue := make(map[string]bool)
-	for username := range m.publicQueue.waitingPlayers {
-		usersInPublicQueue[username] = true
-	}
-	m.publicQueue.mutex.RUnlock()
-
-	// Ne garder que les utilisateurs qui ne sont ni dans des rooms ni dans la file d'attente
+	// Ne garder que les utilisateurs disponibles, ni dans une room ni dans la file d'attente
 	onlineUsers := make([]OnlineUser, 0)
 	for username := range connections {
-		// Vérifier si l'utilisateur n'est ni dans une room ni dans la file d'attente
-		if !usersInRooms[username] && !usersInPublicQueue[username] {
+		if m.presence.Get(username).Status == PresenceIdle {
 			user, err := m.userStore.GetUser(username)
 			if err == nil {
 				onlineUsers = append(onlineUsers, OnlineUser{